@@ -7,6 +7,8 @@
 
 // Package ccboot provides the low level interface to the CC2650
 // bootloader. This may be similar enough to other CC chips.
+// The CC2538 bootloader differs in a few commands, which the device
+// handles once its Variant is known.
 //
 // Used the bootloader interface described in section 8.2 of
 // the following datasheet:
@@ -14,6 +16,7 @@
 package ccboot
 
 import (
+	"context"
 	"io"
 	"log"
 	"time"
@@ -21,11 +24,6 @@ import (
 	"errors"
 )
 
-const (
-	// numAttempts is the number of connect attempts
-	numAttempts int = 3
-)
-
 var ErrSerial = errors.New("Error interacting with reader or writer")
 
 var ErrDevice = errors.New("Unexpected error from device")
@@ -38,15 +36,130 @@ var ErrBadArguments = errors.New("The arguments supplied are invalid")
 
 var ErrNotImplemented = errors.New("This method is not implemented yet")
 
+var ErrDownloadAborted = errors.New("Download was aborted before all data was sent")
+
+// drainMaxReads bounds the number of reads used to discard stale bytes
+const drainMaxReads = 256
+
 type Device struct {
-	port io.ReadWriteCloser
+	port  io.ReadWriteCloser
+	opts  Options
+	ctx   context.Context
+	state *linkState
 }
 
-// NewDevice sets up a new CC bootloader device.
+// linkState holds the protocol state shared by all copies of a Device
+type linkState struct {
+	// drain is set when an exchange was abandoned midway, so that late
+	// bytes from the device are discarded before the next command
+	drain bool
+	// dlRemaining is the number of bytes the device still expects from
+	// SendData after a Download
+	dlRemaining uint32
+	// lastCmd is the most recently sent command, whose response
+	// timeout applies while receiving
+	lastCmd CommandType
+	// variant is the detected variant of the chip whose ID is chipID.
+	// detected is set once DetectVariant or Probe has confirmed it.
+	variant  *Variant
+	chipID   uint32
+	detected bool
+	// flashSize is the flash size found by Probe or by reading the
+	// FLASH_SIZE register, once flashSizeKnown is set
+	flashSize      uint32
+	flashSizeKnown bool
+}
+
+// NewDevice sets up a new CC bootloader device with DefaultOptions.
 //
 // We assume that port.Read has some timeout set
 func NewDevice(port io.ReadWriteCloser) *Device {
-	return &Device{port}
+	return NewDeviceWithOptions(port, DefaultOptions)
+}
+
+// NewDeviceWithOptions sets up a new CC bootloader device that retries
+// and waits as described by opts
+func NewDeviceWithOptions(port io.ReadWriteCloser, opts Options) *Device {
+	return &Device{port, opts.withDefaults(), context.Background(), &linkState{}}
+}
+
+// Context returns the context used by the device's commands
+func (d *Device) Context() context.Context {
+	return d.ctx
+}
+
+// WithContext returns a shallow copy of d that uses ctx for all of its
+// commands. The copy shares the port with d.
+//
+// Cancellation is checked between port reads and writes, so it takes
+// effect within the read timeout of the port. A packet is never cut off
+// halfway, and any response left unread is discarded before the next
+// command. If a Download/SendData sequence is interrupted, the returned
+// error matches both ErrDownloadAborted and the context's error, and the
+// device is left to terminate the download at the next command.
+func (d *Device) WithContext(ctx context.Context) *Device {
+	if ctx == nil {
+		panic("nil context")
+	}
+	d2 := *d
+	d2.ctx = ctx
+	return &d2
+}
+
+// abortError reports a cancellation that interrupted a pending download.
+// It matches ErrDownloadAborted and unwraps to the context's error.
+type abortError struct {
+	cause error
+}
+
+func (e abortError) Error() string {
+	return ErrDownloadAborted.Error() + ": " + e.cause.Error()
+}
+
+func (e abortError) Is(target error) bool {
+	return target == ErrDownloadAborted
+}
+
+func (e abortError) Unwrap() error {
+	return e.cause
+}
+
+// checkContext returns a non-nil error if the device's context is done
+func (d *Device) checkContext() error {
+	err := d.ctx.Err()
+	if err == nil {
+		return nil
+	}
+	if d.state.dlRemaining > 0 {
+		d.state.dlRemaining = 0
+		return abortError{err}
+	}
+	return err
+}
+
+// abandon marks the link as needing a drain and returns the context error
+func (d *Device) abandon(err error) error {
+	d.state.drain = true
+	return err
+}
+
+// drain discards any bytes left over from an abandoned exchange
+func (d *Device) drain() error {
+	buf := make([]byte, 64)
+	for i := 0; i < drainMaxReads; i++ {
+		n, err := d.port.Read(buf)
+		if n > 0 {
+			d.trace(FrameReceived, FrameDiscarded, buf[:n])
+		}
+		if err == io.EOF || (err == nil && n == 0) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	d.state.drain = false
+	return nil
 }
 
 //////////////////////////////////////////////////////////////////////
@@ -55,7 +168,15 @@ func NewDevice(port io.ReadWriteCloser) *Device {
 
 // Sync sends the sync command and waits for the device to respond
 func (d *Device) Sync() error {
-	for attempt := 0; attempt < numAttempts; attempt++ {
+	for attempt := 0; attempt < d.opts.SyncAttempts; attempt++ {
+		if err := d.checkContext(); err != nil {
+			return err
+		}
+		if attempt > 0 {
+			if err := d.backoff(attempt); err != nil {
+				return err
+			}
+		}
 		buf := make([]byte, 100)
 		n, err := d.port.Write(CC_SYNC)
 		if err != nil {
@@ -64,6 +185,7 @@ func (d *Device) Sync() error {
 		if n != len(CC_SYNC) {
 			return ErrSerial
 		}
+		d.trace(FrameSent, FrameSync, CC_SYNC)
 		time.Sleep(time.Millisecond * 10)
 		n, err = d.port.Read(buf)
 		if err != nil {
@@ -73,6 +195,9 @@ func (d *Device) Sync() error {
 			}
 			return err
 		}
+		if n > 0 {
+			d.trace(FrameReceived, FrameSync, buf[:n])
+		}
 		if n != 2 {
 			continue
 		}
@@ -89,9 +214,9 @@ func (d *Device) Sync() error {
 
 func (d *Device) recvNonZero() (byte, error) {
 	buf := make([]byte, 1)
-	attempts := 0
+	wait := d.newResponseWait()
 	for {
-		if attempts > numAttempts {
+		if wait.expired() {
 			return 0, ErrDeviceTimeout
 		}
 
@@ -102,10 +227,12 @@ func (d *Device) recvNonZero() (byte, error) {
 
 		if n == 0 {
 			// timed out waiting for byte
-			attempts++
+			if err := d.checkContext(); err != nil {
+				return 0, d.abandon(err)
+			}
+			wait.miss()
 			continue
 		} else if n == 1 {
-			// fmt.Printf("recv: 0x%.2X\n", buf[0])
 			if buf[0] == 0x00 {
 				// throw away zeros
 				continue
@@ -121,9 +248,9 @@ func (d *Device) recvNonZero() (byte, error) {
 
 func (d *Device) recvByte() (byte, error) {
 	buf := make([]byte, 1)
-	attempts := 0
+	wait := d.newResponseWait()
 	for {
-		if attempts > numAttempts {
+		if wait.expired() {
 			return 0, ErrDeviceTimeout
 		}
 
@@ -134,11 +261,13 @@ func (d *Device) recvByte() (byte, error) {
 
 		if n == 0 {
 			// timed out waiting for byte
-			attempts++
+			if err := d.checkContext(); err != nil {
+				return 0, d.abandon(err)
+			}
+			wait.miss()
 			continue
 		} else if n == 1 {
-			// fmt.Printf("recv: 0x%.2X\n", buf[0])
-			// got an non-zero byte
+			// got a byte
 			return buf[0], nil
 		} else {
 			// not sure what else n could be, must be serial interface
@@ -149,6 +278,9 @@ func (d *Device) recvByte() (byte, error) {
 
 func (d *Device) recvAck() (byte, error) {
 	b, err := d.recvNonZero()
+	if err == nil {
+		d.traceAck(FrameReceived, b)
+	}
 	return b, err
 }
 
@@ -162,6 +294,7 @@ func (d *Device) sendAck(ack byte) error {
 	if n != 1 {
 		return ErrSerial
 	}
+	d.traceAck(FrameSent, ack)
 	return nil
 }
 
@@ -170,8 +303,24 @@ func (d *Device) sendAck(ack byte) error {
 //////////////////////////////////////////////////////////////////////
 
 func (d *Device) SendPacket(pkt []byte) error {
-	for attempt := 0; attempt < numAttempts; attempt++ {
-		// fmt.Printf("Sending Packet: 0x%s\n", hex.EncodeToString(pkt))
+	if d.state.drain {
+		if err := d.drain(); err != nil {
+			return err
+		}
+	}
+	if len(pkt) > 2 {
+		d.state.lastCmd = CommandType(pkt[2])
+	}
+	reason := ReasonTimeout
+	for attempt := 0; attempt < d.opts.SendAttempts; attempt++ {
+		if err := d.checkContext(); err != nil {
+			return err
+		}
+		if attempt > 0 {
+			if err := d.backoff(attempt); err != nil {
+				return err
+			}
+		}
 		n, err := d.port.Write(pkt)
 		if err != nil {
 			return err
@@ -179,9 +328,11 @@ func (d *Device) SendPacket(pkt []byte) error {
 		if n != len(pkt) {
 			return ErrSerial
 		}
+		d.trace(FrameSent, FramePacket, pkt)
 		ack, err := d.recvAck()
 		if err == ErrDeviceTimeout {
 			// try again
+			reason = ReasonTimeout
 			continue
 		} else if err != nil {
 			// bad serial error
@@ -189,23 +340,30 @@ func (d *Device) SendPacket(pkt []byte) error {
 		}
 		if ack == CC_ACK {
 			// success
+			if len(pkt) > 2 {
+				d.trackDownload(CommandType(pkt[2]))
+			}
 			return nil
 		}
 
 		// don't care if it is a NACK or bad characters
 		// try again
+		reason = ReasonGarbage
+		if ack == CC_NACK {
+			reason = ReasonNack
+		}
 	}
 
 	// we spent all of our attempts
-	return ErrDevice
+	return &CommandError{Command: d.state.lastCmd, Attempts: d.opts.SendAttempts, Reason: reason, Err: ErrDevice}
 }
 
 func (d *Device) RecvPacket() ([]byte, error) {
-	for attempt := 0; attempt < numAttempts; attempt++ {
+	for attempt := 0; attempt < d.opts.RecvAttempts; attempt++ {
 		// get packet start size byte
 		size, err := d.recvNonZero()
 		if err != nil {
-			return nil, err
+			return nil, d.commandError(err, attempt+1)
 		}
 		pkt := make([]byte, int(size))
 		pkt[0] = size
@@ -213,10 +371,12 @@ func (d *Device) RecvPacket() ([]byte, error) {
 		for count := 1; count < int(size); count++ {
 			b, err := d.recvByte()
 			if err != nil {
-				return nil, err
+				d.trace(FrameReceived, FramePacket, pkt[:count])
+				return nil, d.abandon(d.commandError(err, attempt+1))
 			}
 			pkt[count] = b
 		}
+		d.trace(FrameReceived, FramePacket, pkt)
 		// decode and verify packet
 		data, err := decodePacket(pkt)
 		if err != nil {
@@ -235,7 +395,15 @@ func (d *Device) RecvPacket() ([]byte, error) {
 		return data, nil
 	}
 
-	return nil, ErrDevice
+	return nil, &CommandError{Command: d.state.lastCmd, Attempts: d.opts.RecvAttempts, Reason: ReasonBadChecksum, Err: ErrDevice}
+}
+
+// trackDownload forgets a pending download once the device has accepted
+// a command that terminates it
+func (d *Device) trackDownload(cmd CommandType) {
+	if cmd != COMMAND_SEND_DATA && cmd != COMMAND_GET_STATUS {
+		d.state.dlRemaining = 0
+	}
 }
 
 //////////////////////////////////////////////////////////////////////
@@ -250,7 +418,8 @@ func (d *Device) Ping() error {
 // and how many bytes will be sent by the following SendData command.
 //
 // This command must be followed by a GetStatus command to ensure that
-// the program address and program size are valid for the device.
+// the program address and program size are valid for the device,
+// unless the device was created with Options.CheckStatus.
 func (d *Device) Download(address, size uint32) error {
 	data := []byte{
 		byte((address >> (3 * 8)) & 0xFF),
@@ -266,26 +435,85 @@ func (d *Device) Download(address, size uint32) error {
 	if err != nil {
 		return err
 	}
+	d.state.dlRemaining = size
+	if err := d.checkStatus(COMMAND_DOWNLOAD); err != nil {
+		d.state.dlRemaining = 0
+		return err
+	}
+	return nil
+}
+
+// DownloadCRC is like Download, but also gives the CRC32 of the size
+// bytes that will follow, as computed by ChecksumCRC32 with no repeated
+// reads. Once the last SendData is received, the device checks the
+// programmed flash against crc and reports a mismatch in the status of
+// that SendData.
+//
+// Only CC13x2/CC26x2 bootloaders support this command.
+func (d *Device) DownloadCRC(address, size, crc uint32) error {
+	if err := d.supports(COMMAND_DOWNLOAD_CRC); err != nil {
+		return err
+	}
+	data := []byte{
+		byte((address >> (3 * 8)) & 0xFF),
+		byte((address >> (2 * 8)) & 0xFF),
+		byte((address >> (1 * 8)) & 0xFF),
+		byte((address >> (0 * 8)) & 0xFF),
+		byte((size >> (3 * 8)) & 0xFF),
+		byte((size >> (2 * 8)) & 0xFF),
+		byte((size >> (1 * 8)) & 0xFF),
+		byte((size >> (0 * 8)) & 0xFF),
+		byte((crc >> (3 * 8)) & 0xFF),
+		byte((crc >> (2 * 8)) & 0xFF),
+		byte((crc >> (1 * 8)) & 0xFF),
+		byte((crc >> (0 * 8)) & 0xFF),
+	}
+	err := d.SendPacket(encodeCmdPacket(COMMAND_DOWNLOAD_CRC, data))
+	if err != nil {
+		return err
+	}
+	d.state.dlRemaining = size
+	if err := d.checkStatus(COMMAND_DOWNLOAD_CRC); err != nil {
+		d.state.dlRemaining = 0
+		return err
+	}
 	return nil
 }
 
-// SendData must only follow a Download command or another SendData
-// command, if more data is needed.
+// SendData must only follow a Download or DownloadCRC command or another
+// SendData command, if more data is needed.
 // Consecutive SendData commands automatically increment the address
 // and continue programming from the previous location.
 //
 // The command terminates programming when the number of bytes
 // indicated by the Download command is received.
 // Each time this function is called, send a GetStatus command to
-// ensure that the data was successfully programmed into the flash.
+// ensure that the data was successfully programmed into the flash,
+// unless the device was created with Options.CheckStatus.
 // 252 is max data size
 func (d *Device) SendData(data []byte) error {
 	if len(data) > SendDataMaxSize {
 		return ErrBadArguments
 	}
-	return d.SendPacket(encodeCmdPacket(COMMAND_SEND_DATA, data))
+	err := d.SendPacket(encodeCmdPacket(COMMAND_SEND_DATA, data))
+	if err != nil {
+		return err
+	}
+	if uint32(len(data)) < d.state.dlRemaining {
+		d.state.dlRemaining -= uint32(len(data))
+	} else {
+		d.state.dlRemaining = 0
+	}
+	if err := d.checkStatus(COMMAND_SEND_DATA); err != nil {
+		d.state.dlRemaining = 0
+		return err
+	}
+	return nil
 }
 
+// SectorErase erases the sector containing address. On bootloaders
+// whose erase takes a size, the size sent is that of SectorSize: the
+// sector size of Options.Geometry, or else the variant's EraseSize.
 func (d *Device) SectorErase(address uint32) error {
 	data := []byte{
 		byte((address >> (3 * 8)) & 0xFF),
@@ -293,7 +521,28 @@ func (d *Device) SectorErase(address uint32) error {
 		byte((address >> (1 * 8)) & 0xFF),
 		byte((address >> (0 * 8)) & 0xFF),
 	}
-	return d.SendPacket(encodeCmdPacket(COMMAND_SECTOR_ERASE, data))
+	if v := d.Variant(); v.SizedErase {
+		size := v.EraseSize
+		if g := d.opts.Geometry; g.valid() {
+			size = g.SectorSize
+		}
+		address -= address % size
+		data = []byte{
+			byte((address >> (3 * 8)) & 0xFF),
+			byte((address >> (2 * 8)) & 0xFF),
+			byte((address >> (1 * 8)) & 0xFF),
+			byte((address >> (0 * 8)) & 0xFF),
+			byte((size >> (3 * 8)) & 0xFF),
+			byte((size >> (2 * 8)) & 0xFF),
+			byte((size >> (1 * 8)) & 0xFF),
+			byte((size >> (0 * 8)) & 0xFF),
+		}
+	}
+	err := d.SendPacket(encodeCmdPacket(COMMAND_SECTOR_ERASE, data))
+	if err != nil {
+		return err
+	}
+	return d.checkStatus(COMMAND_SECTOR_ERASE)
 }
 
 func (d *Device) GetStatus() (Status, error) {
@@ -306,7 +555,7 @@ func (d *Device) GetStatus() (Status, error) {
 		return 0, err
 	}
 	if len(data) != 1 {
-		return Status(0), ErrDevice
+		return Status(0), d.badResponse()
 	}
 	return Status(data[0]), nil
 }
@@ -326,15 +575,25 @@ func (d *Device) GetChipID() (uint32, error) {
 		return 0, err
 	}
 	if len(data) != 4 {
-		return 0, ErrDevice
+		return 0, d.badResponse()
 	}
 	id |= uint32(data[0]) << (3 * 8)
 	id |= uint32(data[1]) << (2 * 8)
 	id |= uint32(data[2]) << (1 * 8)
 	id |= uint32(data[3]) << (0 * 8)
+	if d.state.variant == nil || d.state.chipID != id {
+		// keep a variant found by DetectVariant for the same chip
+		d.state.variant = VariantForChipID(id)
+		d.state.chipID = id
+		d.state.detected = false
+		d.state.flashSizeKnown = false
+	}
 	return id, nil
 }
 
+// CRC32 calculates the CRC32 of size bytes at address, reading each
+// byte rcount+1 times. Bootloaders without a read count only accept an
+// rcount of 0.
 func (d *Device) CRC32(address, size, rcount uint32) (uint32, error) {
 	var crc uint32
 	data := []byte{
@@ -351,6 +610,12 @@ func (d *Device) CRC32(address, size, rcount uint32) (uint32, error) {
 		byte((rcount >> (1 * 8)) & 0xFF),
 		byte((rcount >> (0 * 8)) & 0xFF),
 	}
+	if !d.Variant().CRC32ReadCount {
+		if rcount != 0 {
+			return 0, ErrBadArguments
+		}
+		data = data[:8]
+	}
 	err := d.SendPacket(encodeCmdPacket(COMMAND_CRC32, data))
 	if err != nil {
 		return 0, err
@@ -359,6 +624,9 @@ func (d *Device) CRC32(address, size, rcount uint32) (uint32, error) {
 	if err != nil {
 		return 0, err
 	}
+	if len(data) != 4 {
+		return 0, d.badResponse()
+	}
 	crc |= uint32(data[0]) << (3 * 8)
 	crc |= uint32(data[1]) << (2 * 8)
 	crc |= uint32(data[2]) << (1 * 8)
@@ -367,7 +635,14 @@ func (d *Device) CRC32(address, size, rcount uint32) (uint32, error) {
 }
 
 func (d *Device) BankErase() error {
-	return d.SendPacket(encodeCmdPacket(COMMAND_BANK_ERASE, nil))
+	if err := d.supports(COMMAND_BANK_ERASE); err != nil {
+		return err
+	}
+	err := d.SendPacket(encodeCmdPacket(COMMAND_BANK_ERASE, nil))
+	if err != nil {
+		return err
+	}
+	return d.checkStatus(COMMAND_BANK_ERASE)
 }
 
 func (d *Device) MemoryRead(address uint32, typ ReadWriteType, count uint8) ([]byte, error) {
@@ -377,6 +652,9 @@ func (d *Device) MemoryRead(address uint32, typ ReadWriteType, count uint8) ([]b
 	if typ == ReadWriteType32Bit && count > ReadMaxCount32Bit {
 		return nil, ErrBadArguments
 	}
+	if d.Variant().SingleRead {
+		return d.memoryReadSingle(address, typ, count)
+	}
 	data := []byte{
 		byte((address >> (3 * 8)) & 0xFF),
 		byte((address >> (2 * 8)) & 0xFF),
@@ -396,7 +674,47 @@ func (d *Device) MemoryRead(address uint32, typ ReadWriteType, count uint8) ([]b
 	return data, nil
 }
 
+// memoryReadSingle reads count units with one COMMAND_MEMORY_READ each,
+// for bootloaders that take the access width instead of a count
+func (d *Device) memoryReadSingle(address uint32, typ ReadWriteType, count uint8) ([]byte, error) {
+	width := uint32(1)
+	if typ == ReadWriteType32Bit {
+		width = 4
+	}
+	buf := make([]byte, 0, uint32(count)*width)
+	for i := uint32(0); i < uint32(count); i++ {
+		addr := address + i*width
+		data := []byte{
+			byte((addr >> (3 * 8)) & 0xFF),
+			byte((addr >> (2 * 8)) & 0xFF),
+			byte((addr >> (1 * 8)) & 0xFF),
+			byte((addr >> (0 * 8)) & 0xFF),
+			byte(width),
+		}
+		err := d.SendPacket(encodeCmdPacket(COMMAND_MEMORY_READ, data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = d.RecvPacket()
+		if err != nil {
+			return nil, err
+		}
+		// the response always holds a full word
+		if len(data) != 4 {
+			return nil, d.badResponse()
+		}
+		buf = append(buf, data[:width]...)
+	}
+	return buf, nil
+}
+
 func (d *Device) MemoryWrite(address uint32, typ ReadWriteType, data []byte) error {
+	if err := d.supports(COMMAND_MEMORY_WRITE); err != nil {
+		return err
+	}
+	if d.Variant().SingleWrite {
+		return d.memoryWriteSingle(address, typ, data)
+	}
 	if typ == ReadWriteType8Bit && uint8(len(data)) > WriteMaxCount8Bit {
 		return ErrBadArguments
 	}
@@ -420,10 +738,48 @@ func (d *Device) MemoryWrite(address uint32, typ ReadWriteType, data []byte) err
 	if err != nil {
 		return err
 	}
+	return d.checkStatus(COMMAND_MEMORY_WRITE)
+}
+
+// memoryWriteSingle writes data with one COMMAND_MEMORY_WRITE per unit,
+// for bootloaders that take a single word and the access width instead
+// of a count
+func (d *Device) memoryWriteSingle(address uint32, typ ReadWriteType, data []byte) error {
+	width := 1
+	if typ == ReadWriteType32Bit {
+		width = 4
+	}
+	if len(data)%width != 0 {
+		return ErrBadArguments
+	}
+	for off := 0; off < len(data); off += width {
+		addr := address + uint32(off)
+		buf := []byte{
+			byte((addr >> (3 * 8)) & 0xFF),
+			byte((addr >> (2 * 8)) & 0xFF),
+			byte((addr >> (1 * 8)) & 0xFF),
+			byte((addr >> (0 * 8)) & 0xFF),
+		}
+		// the unit is sent in a full word
+		word := make([]byte, 4)
+		copy(word, data[off:off+width])
+		buf = append(buf, word...)
+		buf = append(buf, byte(width))
+		err := d.SendPacket(encodeCmdPacket(COMMAND_MEMORY_WRITE, buf))
+		if err != nil {
+			return err
+		}
+		if err := d.checkStatus(COMMAND_MEMORY_WRITE); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (d *Device) SetCCFG(id CCFG_FieldID, value uint32) error {
+	if err := d.supports(COMMAND_SET_CCFG); err != nil {
+		return err
+	}
 	data := []byte{
 		byte((id >> (3 * 8)) & 0xFF),
 		byte((id >> (2 * 8)) & 0xFF),
@@ -438,7 +794,7 @@ func (d *Device) SetCCFG(id CCFG_FieldID, value uint32) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return d.checkStatus(COMMAND_SET_CCFG)
 }
 
 //////////////////////////////////////////////////////////////////////
@@ -34,7 +34,8 @@ func TestCCBoot(t *testing.T) {
 	t.Log("# Opening Serial")
 	port, err := serial.Open(options)
 	if err != nil {
-		t.Errorf("serial.Open: %v", err)
+		// no board attached, see ccboottest for a simulated bootloader
+		t.Skipf("serial.Open: %v", err)
 	}
 	// Make sure to close it later.
 	defer port.Close()
@@ -65,7 +66,7 @@ func TestCCBoot(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error reading chip id: %s\n", err.Error())
 	}
-	t.Logf("Status is 0x%.2X = %s\n", byte(status), status.GetString())
+	t.Logf("Status is 0x%.2X = %s\n", byte(status), status.String())
 
 	// Get Chip ID
 	t.Log("# Getting Chip ID")
@@ -82,7 +83,7 @@ func TestCCBoot(t *testing.T) {
 		if err != nil {
 			t.Errorf("Error reading chip id: %s\n", err.Error())
 		}
-		t.Logf("Status is 0x%.2X = %s\n", byte(status), status.GetString())
+		t.Logf("Status is 0x%.2X = %s\n", byte(status), status.String())
 	}
 
 	// Bank Erase
@@ -99,7 +100,7 @@ func TestCCBoot(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error reading chip id: %s\n", err.Error())
 	}
-	t.Logf("Status is 0x%.2X = %s\n", byte(status), status.GetString())
+	t.Logf("Status is 0x%.2X = %s\n", byte(status), status.String())
 
 	// Reset Device
 	t.Log("# Resetting Device")
@@ -0,0 +1,731 @@
+// Package ccboottest provides an in-memory simulation of the CC26xx
+// serial bootloader, so that code built on ccboot.Device can be tested
+// without hardware.
+//
+// The simulated Bootloader implements io.ReadWriteCloser and can be
+// handed directly to ccboot.NewDevice.
+package ccboottest
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/openchirp/ccboot"
+)
+
+// Config describes the simulated chip
+type Config struct {
+	// ChipID is returned by COMMAND_GET_CHIP_ID
+	ChipID uint32
+	// WaferID, if set, is reported by the ICEPICK_DEVICE_ID register
+	WaferID uint32
+	// FlashBase and FlashSize set the flash address range
+	FlashBase uint32
+	FlashSize uint32
+	// SectorSize is the erase granularity of the flash
+	SectorSize uint32
+	// CCFGSize is the size of the CCFG block at the end of flash, or 0
+	// if the chip has none
+	CCFGSize uint32
+	// Variant selects the bootloader dialect, ccboot.VariantCC26xx if nil
+	Variant *ccboot.Variant
+	// ReadTimeout is how long Read blocks when no bytes are pending,
+	// emulating the read timeout of a serial port
+	ReadTimeout time.Duration
+}
+
+// DefaultConfig simulates the CC2650F128 described in config.json
+var DefaultConfig = Config{
+	ChipID:      0x8002F000,
+	WaferID:     0xB99A,
+	FlashBase:   0x00000000,
+	FlashSize:   0x00020000,
+	SectorSize:  0x1000,
+	CCFGSize:    0x58,
+	ReadTimeout: time.Millisecond,
+}
+
+// CC26x2Config simulates a CC2652R1F, whose bootloader speaks
+// ccboot.VariantCC26x2
+var CC26x2Config = Config{
+	ChipID:      0x2082F000,
+	WaferID:     0xBB41,
+	FlashBase:   0x00000000,
+	FlashSize:   0x00058000,
+	SectorSize:  0x2000,
+	CCFGSize:    0x58,
+	Variant:     ccboot.VariantCC26x2,
+	ReadTimeout: time.Millisecond,
+}
+
+// CC2538Config simulates a CC2538SF53, whose bootloader speaks
+// ccboot.VariantCC2538
+var CC2538Config = Config{
+	ChipID:      0xB964,
+	FlashBase:   0x00200000,
+	FlashSize:   0x00080000,
+	SectorSize:  0x800,
+	Variant:     ccboot.VariantCC2538,
+	ReadTimeout: time.Millisecond,
+}
+
+// Offsets of the CCFG registers used by the simulator, relative to the
+// start of the CCFG block
+const (
+	ccfgBLConfig   = 0x30
+	ccfgEraseConf  = 0x34
+	ccfgTIOptions  = 0x38
+	ccfgTapDap0    = 0x3C
+	ccfgTapDap1    = 0x40
+	ccfgImageValid = 0x44
+	ccfgProt31_0   = 0x48
+)
+
+type ccfgField struct {
+	offset uint32
+	shift  uint
+	width  uint
+}
+
+// ccfgFields maps each SET_CCFG field ID to its location in the CCFG
+var ccfgFields = map[ccboot.CCFG_FieldID]ccfgField{
+	ccboot.ID_SECTOR_PROT:       {ccfgProt31_0, 0, 32},
+	ccboot.ID_IMAGE_VALID:       {ccfgImageValid, 0, 32},
+	ccboot.ID_TEST_TAP_LCK:      {ccfgTapDap0, 0, 8},
+	ccboot.ID_PRCM_TAP_LCK:      {ccfgTapDap0, 8, 8},
+	ccboot.ID_CPU_DAP_LCK:       {ccfgTapDap0, 16, 8},
+	ccboot.ID_WUC_TAP_LCK:       {ccfgTapDap1, 0, 8},
+	ccboot.ID_PBIST1_TAP_LCK:    {ccfgTapDap1, 8, 8},
+	ccboot.ID_PBIST2_TAP_LCK:    {ccfgTapDap1, 16, 8},
+	ccboot.ID_BANK_ERASE_DIS:    {ccfgEraseConf, 0, 1},
+	ccboot.ID_CHIP_ERASE_DIS:    {ccfgEraseConf, 8, 1},
+	ccboot.ID_TI_FA_ENABLE:      {ccfgTIOptions, 0, 8},
+	ccboot.ID_BL_BACKDOOR_EN:    {ccfgBLConfig, 0, 8},
+	ccboot.ID_BL_BACKDOOR_PIN:   {ccfgBLConfig, 8, 8},
+	ccboot.ID_BL_BACKDOOR_LEVEL: {ccfgBLConfig, 16, 1},
+	ccboot.ID_BL_ENABLE:         {ccfgBLConfig, 24, 8},
+}
+
+// Bootloader is a simulated CC26xx ROM bootloader.
+//
+// Flash behaves like the real part: an erased sector reads back 0xFF and
+// programming can only clear bits. Addresses outside of flash are backed
+// by a sparse memory that MemoryRead and MemoryWrite operate on, in
+// which the ICEPICK_DEVICE_ID and FLASH_SIZE registers are set up from
+// the Config.
+type Bootloader struct {
+	mu     sync.Mutex
+	cfg    Config
+	flash  []byte
+	mem    map[uint32]byte
+	in     []byte // bytes received from the host, not yet processed
+	out    []byte // bytes waiting to be read by the host
+	resp   []byte // response packet waiting to be ACKed by the host
+	synced bool
+	closed bool
+	status ccboot.Status
+
+	downloading bool
+	dlAddress   uint32
+	dlRemaining uint32
+	// dlStart, dlSize and dlCRC describe a COMMAND_DOWNLOAD_CRC, whose
+	// programmed flash is checked once all data is received
+	dlCheckCRC bool
+	dlStart    uint32
+	dlSize     uint32
+	dlCRC      uint32
+}
+
+// Addresses of the registers set up from the Config
+const (
+	regICEPickDeviceID = 0x50001318
+	regFlashSize       = 0x4003002C
+)
+
+// NewBootloader creates a simulated bootloader with fully erased flash
+func NewBootloader(cfg Config) *Bootloader {
+	b := &Bootloader{
+		cfg:    cfg,
+		flash:  make([]byte, cfg.FlashSize),
+		mem:    make(map[uint32]byte),
+		status: ccboot.COMMAND_RET_SUCCESS,
+	}
+	for i := range b.flash {
+		b.flash[i] = 0xFF
+	}
+	if cfg.WaferID != 0 {
+		b.setRegister(regICEPickDeviceID, cfg.WaferID<<12|0x02F)
+	}
+	if cfg.CCFGSize != 0 && cfg.SectorSize != 0 {
+		b.setRegister(regFlashSize, cfg.FlashSize/cfg.SectorSize)
+	}
+	return b
+}
+
+// Read returns pending bytes from the bootloader. When nothing is
+// pending, it waits ReadTimeout and returns 0 bytes, like a serial port
+// whose read timed out.
+func (b *Bootloader) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if len(b.out) == 0 && !b.closed {
+		b.mu.Unlock()
+		time.Sleep(b.cfg.ReadTimeout)
+		b.mu.Lock()
+	}
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n := copy(p, b.out)
+	b.out = b.out[n:]
+	return n, nil
+}
+
+// Write feeds bytes to the bootloader, which processes them immediately
+func (b *Bootloader) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.in = append(b.in, p...)
+	b.process()
+	return len(p), nil
+}
+
+// Close closes the simulated port
+func (b *Bootloader) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	return nil
+}
+
+// Synced reports whether the bootloader has received the sync word
+func (b *Bootloader) Synced() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.synced
+}
+
+// Status returns the status that COMMAND_GET_STATUS would report
+func (b *Bootloader) Status() ccboot.Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status
+}
+
+// Flash returns a copy of the whole flash contents
+func (b *Bootloader) Flash() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.flash...)
+}
+
+// LoadFlash overwrites flash at address with data, bypassing the
+// erase/program rules. It is meant for setting up test fixtures.
+func (b *Bootloader) LoadFlash(address uint32, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	copy(b.flash[address-b.cfg.FlashBase:], data)
+}
+
+// SetMemory stores data in the non-flash memory at address, for example
+// to emulate device registers
+func (b *Bootloader) SetMemory(address uint32, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, v := range data {
+		b.mem[address+uint32(i)] = v
+	}
+}
+
+//////////////////////////////////////////////////////////////////////
+//                        Wire Protocol                             //
+//////////////////////////////////////////////////////////////////////
+
+func (b *Bootloader) process() {
+	for len(b.in) > 0 {
+		if !b.synced {
+			// wait for the sync word, ignoring anything else
+			if len(b.in) < len(ccboot.CC_SYNC) {
+				return
+			}
+			if b.in[0] == ccboot.CC_SYNC[0] && b.in[1] == ccboot.CC_SYNC[1] {
+				b.in = b.in[2:]
+				b.synced = true
+				b.out = append(b.out, 0x00, ccboot.CC_ACK)
+				continue
+			}
+			b.in = b.in[1:]
+			continue
+		}
+
+		if b.resp != nil {
+			// waiting for the host to acknowledge our response
+			ack := b.in[0]
+			b.in = b.in[1:]
+			switch ack {
+			case ccboot.CC_ACK:
+				b.resp = nil
+			case ccboot.CC_NACK:
+				b.out = append(b.out, b.resp...)
+			}
+			continue
+		}
+
+		size := int(b.in[0])
+		if size == 0 {
+			b.in = b.in[1:]
+			continue
+		}
+		if size < 3 {
+			// can never be a valid packet
+			b.in = b.in[1:]
+			b.out = append(b.out, 0x00, ccboot.CC_NACK)
+			continue
+		}
+		if len(b.in) < size {
+			// wait for the rest of the packet
+			return
+		}
+		pkt := b.in[:size]
+		b.in = b.in[size:]
+		if checksum(pkt[2:]) != pkt[1] {
+			b.out = append(b.out, 0x00, ccboot.CC_NACK)
+			continue
+		}
+		b.out = append(b.out, 0x00, ccboot.CC_ACK)
+		b.execute(ccboot.CommandType(pkt[2]), append([]byte(nil), pkt[3:]...))
+	}
+}
+
+// respond queues a response packet for the host
+func (b *Bootloader) respond(data []byte) {
+	pkt := make([]byte, 2+len(data))
+	pkt[0] = byte(len(pkt))
+	pkt[1] = checksum(data)
+	copy(pkt[2:], data)
+	b.out = append(b.out, pkt...)
+	b.resp = pkt
+}
+
+func checksum(data []byte) byte {
+	var sum byte
+	for _, v := range data {
+		sum += v
+	}
+	return sum
+}
+
+//////////////////////////////////////////////////////////////////////
+//                        Command Handling                          //
+//////////////////////////////////////////////////////////////////////
+
+func (b *Bootloader) execute(cmd ccboot.CommandType, params []byte) {
+	if cmd != ccboot.COMMAND_SEND_DATA && cmd != ccboot.COMMAND_GET_STATUS {
+		// any other command terminates a pending download
+		b.downloading = false
+	}
+
+	if !b.variant().Supports(cmd) {
+		b.status = ccboot.COMMAND_RET_UNKNOW_CMD
+		return
+	}
+
+	switch cmd {
+	case ccboot.COMMAND_PING:
+		b.status = ccboot.COMMAND_RET_SUCCESS
+	case ccboot.COMMAND_GET_STATUS:
+		b.respond([]byte{byte(b.status)})
+	case ccboot.COMMAND_GET_CHIP_ID:
+		b.status = ccboot.COMMAND_RET_SUCCESS
+		b.respond(be32(b.cfg.ChipID))
+	case ccboot.COMMAND_RESET:
+		b.status = ccboot.COMMAND_RET_SUCCESS
+		b.synced = false
+	case ccboot.COMMAND_DOWNLOAD:
+		b.status = b.download(params)
+	case ccboot.COMMAND_DOWNLOAD_CRC:
+		b.status = b.downloadCRC(params)
+	case ccboot.COMMAND_SEND_DATA:
+		b.status = b.sendData(params)
+	case ccboot.COMMAND_SECTOR_ERASE:
+		b.status = b.sectorErase(params)
+	case ccboot.COMMAND_BANK_ERASE:
+		b.status = b.bankErase()
+	case ccboot.COMMAND_CRC32:
+		b.status = b.crc32(params)
+	case ccboot.COMMAND_MEMORY_READ:
+		b.status = b.memoryRead(params)
+	case ccboot.COMMAND_MEMORY_WRITE:
+		b.status = b.memoryWrite(params)
+	case ccboot.COMMAND_SET_CCFG:
+		b.status = b.setCCFG(params)
+	default:
+		b.status = ccboot.COMMAND_RET_UNKNOW_CMD
+	}
+}
+
+func (b *Bootloader) download(params []byte) ccboot.Status {
+	if len(params) != 8 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	address := binary.BigEndian.Uint32(params[0:4])
+	size := binary.BigEndian.Uint32(params[4:8])
+	if address%4 != 0 || size%4 != 0 || size == 0 || !b.inFlash(address, size) {
+		return ccboot.COMMAND_RET_INVALID_ADR
+	}
+	b.downloading = true
+	b.dlCheckCRC = false
+	b.dlAddress = address
+	b.dlRemaining = size
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+func (b *Bootloader) downloadCRC(params []byte) ccboot.Status {
+	if len(params) != 12 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	status := b.download(params[:8])
+	if status != ccboot.COMMAND_RET_SUCCESS {
+		return status
+	}
+	b.dlCheckCRC = true
+	b.dlStart = b.dlAddress
+	b.dlSize = b.dlRemaining
+	b.dlCRC = binary.BigEndian.Uint32(params[8:12])
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+func (b *Bootloader) sendData(data []byte) ccboot.Status {
+	if !b.downloading || len(data) == 0 || uint32(len(data)) > b.dlRemaining {
+		b.downloading = false
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	for i, v := range data {
+		address := b.dlAddress + uint32(i)
+		if b.protected(address) {
+			b.downloading = false
+			return ccboot.COMMAND_RET_FLASH_FAIL
+		}
+		// programming can only clear bits
+		b.flash[address-b.cfg.FlashBase] &= v
+	}
+	b.dlAddress += uint32(len(data))
+	b.dlRemaining -= uint32(len(data))
+	if b.dlRemaining == 0 {
+		b.downloading = false
+		if b.dlCheckCRC {
+			offset := b.dlStart - b.cfg.FlashBase
+			if crc32.ChecksumIEEE(b.flash[offset:offset+b.dlSize]) != b.dlCRC {
+				return ccboot.COMMAND_RET_FLASH_FAIL
+			}
+		}
+	}
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+func (b *Bootloader) sectorErase(params []byte) ccboot.Status {
+	if b.variant().SizedErase {
+		return b.pageErase(params)
+	}
+	if len(params) != 4 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	address := binary.BigEndian.Uint32(params)
+	if !b.inFlash(address, 1) {
+		return ccboot.COMMAND_RET_INVALID_ADR
+	}
+	if b.protected(address) {
+		return ccboot.COMMAND_RET_FLASH_FAIL
+	}
+	b.erase(address)
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+// pageErase is the CC2538 erase, which takes an address and a size that
+// must both be page aligned
+func (b *Bootloader) pageErase(params []byte) ccboot.Status {
+	if len(params) != 8 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	address := binary.BigEndian.Uint32(params[0:4])
+	size := binary.BigEndian.Uint32(params[4:8])
+	if address%b.cfg.SectorSize != 0 || size%b.cfg.SectorSize != 0 || size == 0 || !b.inFlash(address, size) {
+		return ccboot.COMMAND_RET_INVALID_ADR
+	}
+	for a := address; a-address < size; a += b.cfg.SectorSize {
+		b.erase(a)
+	}
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+func (b *Bootloader) bankErase() ccboot.Status {
+	if b.cfg.CCFGSize != 0 && b.ccfgRead(ccfgEraseConf)&0x1 == 0 {
+		// BANK_ERASE_DIS_N is cleared
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	failed := false
+	for address := b.cfg.FlashBase; address-b.cfg.FlashBase < b.cfg.FlashSize; address += b.cfg.SectorSize {
+		if b.protected(address) {
+			failed = true
+			continue
+		}
+		b.erase(address)
+	}
+	if failed {
+		return ccboot.COMMAND_RET_FLASH_FAIL
+	}
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+func (b *Bootloader) crc32(params []byte) ccboot.Status {
+	var rcount uint32
+	if b.variant().CRC32ReadCount {
+		if len(params) != 12 {
+			return ccboot.COMMAND_RET_INVALID_CMD
+		}
+		rcount = binary.BigEndian.Uint32(params[8:12])
+	} else if len(params) != 8 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	address := binary.BigEndian.Uint32(params[0:4])
+	size := binary.BigEndian.Uint32(params[4:8])
+	// each location is read once, plus rcount repeated reads
+	crc := uint32(0)
+	buf := make([]byte, rcount+1)
+	for i := uint32(0); i < size; i++ {
+		v := b.readByte(address + i)
+		for j := range buf {
+			buf[j] = v
+		}
+		crc = crc32.Update(crc, crc32.IEEETable, buf)
+	}
+	b.respond(be32(crc))
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+func (b *Bootloader) memoryRead(params []byte) ccboot.Status {
+	if b.variant().SingleRead {
+		return b.memoryReadSingle(params)
+	}
+	if len(params) != 6 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	address := binary.BigEndian.Uint32(params[0:4])
+	typ := ccboot.ReadWriteType(params[4])
+	count := params[5]
+	width := uint32(1)
+	switch typ {
+	case ccboot.ReadWriteType8Bit:
+		if count > ccboot.ReadMaxCount8Bit {
+			return ccboot.COMMAND_RET_INVALID_CMD
+		}
+	case ccboot.ReadWriteType32Bit:
+		if count > ccboot.ReadMaxCount32Bit {
+			return ccboot.COMMAND_RET_INVALID_CMD
+		}
+		if address%4 != 0 {
+			return ccboot.COMMAND_RET_INVALID_ADR
+		}
+		width = 4
+	default:
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	data := make([]byte, uint32(count)*width)
+	for i := range data {
+		data[i] = b.readByte(address + uint32(i))
+	}
+	b.respond(data)
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+// memoryReadSingle is the CC2538 read, which takes an access width of 1
+// or 4 bytes and always responds with a full word
+func (b *Bootloader) memoryReadSingle(params []byte) ccboot.Status {
+	if len(params) != 5 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	address := binary.BigEndian.Uint32(params[0:4])
+	width := uint32(params[4])
+	if width != 1 && width != 4 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	if address%width != 0 {
+		return ccboot.COMMAND_RET_INVALID_ADR
+	}
+	data := make([]byte, 4)
+	for i := uint32(0); i < width; i++ {
+		data[i] = b.readByte(address + i)
+	}
+	b.respond(data)
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+func (b *Bootloader) memoryWrite(params []byte) ccboot.Status {
+	if b.variant().SingleWrite {
+		return b.memoryWriteSingle(params)
+	}
+	if len(params) < 6 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	address := binary.BigEndian.Uint32(params[0:4])
+	typ := ccboot.ReadWriteType(params[4])
+	data := params[5:]
+	switch typ {
+	case ccboot.ReadWriteType8Bit:
+		if uint8(len(data)) > ccboot.WriteMaxCount8Bit {
+			return ccboot.COMMAND_RET_INVALID_CMD
+		}
+	case ccboot.ReadWriteType32Bit:
+		if uint8(len(data)) > ccboot.WriteMaxCount32Bit || len(data)%4 != 0 {
+			return ccboot.COMMAND_RET_INVALID_CMD
+		}
+		if address%4 != 0 {
+			return ccboot.COMMAND_RET_INVALID_ADR
+		}
+	default:
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	if b.overlapsFlash(address, uint32(len(data))) {
+		// flash can only be changed with DOWNLOAD/SEND_DATA
+		return ccboot.COMMAND_RET_INVALID_ADR
+	}
+	for i, v := range data {
+		b.mem[address+uint32(i)] = v
+	}
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+// memoryWriteSingle is the CC2538 write, which takes one unit in a full
+// word followed by its access width of 1 or 4 bytes
+func (b *Bootloader) memoryWriteSingle(params []byte) ccboot.Status {
+	if len(params) != 9 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	address := binary.BigEndian.Uint32(params[0:4])
+	width := uint32(params[8])
+	if width != 1 && width != 4 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	if address%width != 0 {
+		return ccboot.COMMAND_RET_INVALID_ADR
+	}
+	if b.overlapsFlash(address, width) {
+		return ccboot.COMMAND_RET_INVALID_ADR
+	}
+	for i := uint32(0); i < width; i++ {
+		b.mem[address+i] = params[4+i]
+	}
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+func (b *Bootloader) setCCFG(params []byte) ccboot.Status {
+	if b.cfg.CCFGSize == 0 {
+		// there is no CCFG to set
+		return ccboot.COMMAND_RET_UNKNOW_CMD
+	}
+	if len(params) != 8 {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	id := ccboot.CCFG_FieldID(binary.BigEndian.Uint32(params[0:4]))
+	value := binary.BigEndian.Uint32(params[4:8])
+	field, ok := ccfgFields[id]
+	if !ok {
+		return ccboot.COMMAND_RET_INVALID_CMD
+	}
+	mask := uint32(0xFFFFFFFF)
+	if field.width < 32 {
+		mask = (uint32(1)<<field.width - 1) << field.shift
+	}
+	old := b.ccfgRead(field.offset)
+	word := (old &^ mask) | ((value << field.shift) & mask)
+	// the CCFG lives in flash, so bits can only be cleared
+	b.ccfgWrite(field.offset, old&word)
+	return ccboot.COMMAND_RET_SUCCESS
+}
+
+//////////////////////////////////////////////////////////////////////
+//                          Memory Model                            //
+//////////////////////////////////////////////////////////////////////
+
+func (b *Bootloader) variant() *ccboot.Variant {
+	if b.cfg.Variant != nil {
+		return b.cfg.Variant
+	}
+	return ccboot.VariantCC26xx
+}
+
+// setRegister stores a 32 bit register in memory
+func (b *Bootloader) setRegister(address, value uint32) {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, value)
+	for i, v := range buf {
+		b.mem[address+uint32(i)] = v
+	}
+}
+
+func (b *Bootloader) inFlash(address, size uint32) bool {
+	start := uint64(b.cfg.FlashBase)
+	end := start + uint64(b.cfg.FlashSize)
+	return uint64(address) >= start && uint64(address)+uint64(size) <= end
+}
+
+func (b *Bootloader) overlapsFlash(address, size uint32) bool {
+	start := uint64(b.cfg.FlashBase)
+	end := start + uint64(b.cfg.FlashSize)
+	return uint64(address) < end && uint64(address)+uint64(size) > start
+}
+
+func (b *Bootloader) readByte(address uint32) byte {
+	if b.inFlash(address, 1) {
+		return b.flash[address-b.cfg.FlashBase]
+	}
+	return b.mem[address]
+}
+
+// erase sets the sector containing address to 0xFF
+func (b *Bootloader) erase(address uint32) {
+	offset := address - b.cfg.FlashBase
+	start := offset - offset%b.cfg.SectorSize
+	for i := start; i < start+b.cfg.SectorSize && i < b.cfg.FlashSize; i++ {
+		b.flash[i] = 0xFF
+	}
+}
+
+// protected reports whether the sector containing address is write
+// protected by CCFG_PROT_31_0
+func (b *Bootloader) protected(address uint32) bool {
+	if b.cfg.CCFGSize == 0 {
+		return false
+	}
+	sector := (address - b.cfg.FlashBase) / b.cfg.SectorSize
+	if sector >= 32 {
+		return false
+	}
+	return b.ccfgRead(ccfgProt31_0)&(1<<sector) == 0
+}
+
+func (b *Bootloader) ccfgOffset(reg uint32) uint32 {
+	return b.cfg.FlashSize - b.cfg.CCFGSize + reg
+}
+
+func (b *Bootloader) ccfgRead(reg uint32) uint32 {
+	offset := b.ccfgOffset(reg)
+	return binary.LittleEndian.Uint32(b.flash[offset : offset+4])
+}
+
+func (b *Bootloader) ccfgWrite(reg, value uint32) {
+	offset := b.ccfgOffset(reg)
+	binary.LittleEndian.PutUint32(b.flash[offset:offset+4], value)
+}
+
+func be32(v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, v)
+	return buf
+}
@@ -0,0 +1,251 @@
+package ccboottest
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+)
+
+func newSyncedDevice(t *testing.T) (*ccboot.Device, *Bootloader) {
+	b := NewBootloader(DefaultConfig)
+	d := ccboot.NewDevice(b)
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+	return d, b
+}
+
+func expectStatus(t *testing.T, d *ccboot.Device, expected ccboot.Status) {
+	t.Helper()
+	status, err := d.GetStatus()
+	if err != nil {
+		t.Fatalf("Error getting status: %v", err)
+	}
+	if status != expected {
+		t.Fatalf("Status is %v, expected %v", status, expected)
+	}
+}
+
+func TestSyncAndPing(t *testing.T) {
+	d, b := newSyncedDevice(t)
+	if !b.Synced() {
+		t.Fatal("Simulator did not register sync")
+	}
+	for i := 0; i < 3; i++ {
+		if err := d.Ping(); err != nil {
+			t.Fatalf("Error pinging: %v", err)
+		}
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_SUCCESS)
+
+	id, err := d.GetChipID()
+	if err != nil {
+		t.Fatalf("Error reading chip id: %v", err)
+	}
+	if id != DefaultConfig.ChipID {
+		t.Errorf("Chip ID is 0x%X, expected 0x%X", id, DefaultConfig.ChipID)
+	}
+}
+
+func TestDownloadSendData(t *testing.T) {
+	d, b := newSyncedDevice(t)
+	data := make([]byte, 2*ccboot.SendDataMaxSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	if err := d.Download(0x1000, uint32(len(data))); err != nil {
+		t.Fatalf("Error sending download: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_SUCCESS)
+	for offset := 0; offset < len(data); offset += ccboot.SendDataMaxSize {
+		if err := d.SendData(data[offset : offset+ccboot.SendDataMaxSize]); err != nil {
+			t.Fatalf("Error sending data: %v", err)
+		}
+		expectStatus(t, d, ccboot.COMMAND_RET_SUCCESS)
+	}
+	if !bytes.Equal(b.Flash()[0x1000:0x1000+len(data)], data) {
+		t.Error("Flash contents do not match the programmed data")
+	}
+
+	// the download is complete, so more data is an error
+	if err := d.SendData([]byte{0, 0, 0, 0}); err != nil {
+		t.Fatalf("Error sending data: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_INVALID_CMD)
+}
+
+func TestDownloadInvalid(t *testing.T) {
+	d, _ := newSyncedDevice(t)
+
+	if err := d.Download(0x1FFFC, 8); err != nil {
+		t.Fatalf("Error sending download: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_INVALID_ADR)
+
+	if err := d.Download(0x1002, 4); err != nil {
+		t.Fatalf("Error sending download: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_INVALID_ADR)
+
+	if err := d.SendData([]byte{1, 2, 3, 4}); err != nil {
+		t.Fatalf("Error sending data: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_INVALID_CMD)
+}
+
+func TestProgramOnlyClearsBits(t *testing.T) {
+	d, b := newSyncedDevice(t)
+	for _, v := range []byte{0xF0, 0x3C} {
+		if err := d.Download(0x2000, 4); err != nil {
+			t.Fatalf("Error sending download: %v", err)
+		}
+		if err := d.SendData([]byte{v, v, v, v}); err != nil {
+			t.Fatalf("Error sending data: %v", err)
+		}
+		expectStatus(t, d, ccboot.COMMAND_RET_SUCCESS)
+	}
+	if got := b.Flash()[0x2000]; got != 0x30 {
+		t.Errorf("Flash byte is 0x%.2X, expected 0x30", got)
+	}
+
+	if err := d.SectorErase(0x2010); err != nil {
+		t.Fatalf("Error erasing sector: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_SUCCESS)
+	if got := b.Flash()[0x2000]; got != 0xFF {
+		t.Errorf("Flash byte is 0x%.2X after erase, expected 0xFF", got)
+	}
+}
+
+func TestBankErase(t *testing.T) {
+	d, b := newSyncedDevice(t)
+	b.LoadFlash(0x0, bytes.Repeat([]byte{0xAA}, 0x3000))
+	if err := d.BankErase(); err != nil {
+		t.Fatalf("Error bank erasing: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_SUCCESS)
+	for i, v := range b.Flash() {
+		if v != 0xFF {
+			t.Fatalf("Flash byte at 0x%X is 0x%.2X after bank erase", i, v)
+		}
+	}
+}
+
+func TestNoCCFG(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.CCFGSize = 0
+	b := NewBootloader(cfg)
+	d := ccboot.NewDevice(b)
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+	b.LoadFlash(0x1FF00, bytes.Repeat([]byte{0xAA}, 0x100))
+	if err := d.BankErase(); err != nil {
+		t.Fatalf("Error bank erasing: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_SUCCESS)
+	if got := b.Flash()[0x1FFFF]; got != 0xFF {
+		t.Errorf("Last flash byte is 0x%.2X after bank erase", got)
+	}
+
+	if err := d.SetCCFG(ccboot.ID_BL_ENABLE, 0xC5); err != nil {
+		t.Fatalf("Error setting CCFG: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_UNKNOW_CMD)
+}
+
+func TestCRC32(t *testing.T) {
+	d, b := newSyncedDevice(t)
+	data := []byte("The quick brown fox jumps over the lazy dog")
+	b.LoadFlash(0x400, data)
+
+	crc, err := d.CRC32(0x400, uint32(len(data)), 0)
+	if err != nil {
+		t.Fatalf("Error computing CRC32: %v", err)
+	}
+	if expected := crc32.ChecksumIEEE(data); crc != expected {
+		t.Errorf("CRC32 is 0x%.8X, expected 0x%.8X", crc, expected)
+	}
+}
+
+func TestMemoryReadWrite(t *testing.T) {
+	d, _ := newSyncedDevice(t)
+	data := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+
+	if err := d.MemoryWrite(0x20000000, ccboot.ReadWriteType32Bit, data); err != nil {
+		t.Fatalf("Error writing memory: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_SUCCESS)
+
+	got, err := d.MemoryRead(0x20000000, ccboot.ReadWriteType32Bit, 2)
+	if err != nil {
+		t.Fatalf("Error reading memory: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Read back %X, expected %X", got, data)
+	}
+
+	got, err = d.MemoryRead(0x20000003, ccboot.ReadWriteType8Bit, 3)
+	if err != nil {
+		t.Fatalf("Error reading memory: %v", err)
+	}
+	if !bytes.Equal(got, data[3:6]) {
+		t.Errorf("Read back %X, expected %X", got, data[3:6])
+	}
+
+	// flash can not be written with MEMORY_WRITE
+	if err := d.MemoryWrite(0x1000, ccboot.ReadWriteType8Bit, data); err != nil {
+		t.Fatalf("Error writing memory: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_INVALID_ADR)
+}
+
+func TestSetCCFG(t *testing.T) {
+	d, b := newSyncedDevice(t)
+	if err := d.SetCCFG(ccboot.ID_BL_BACKDOOR_PIN, 0x0B); err != nil {
+		t.Fatalf("Error setting CCFG: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_SUCCESS)
+	// BL_CONFIG lives at 0x1FFD8, see config.json
+	if got := b.Flash()[0x1FFD9]; got != 0x0B {
+		t.Errorf("BL_PIN_NUMBER is 0x%.2X, expected 0x0B", got)
+	}
+
+	// protect sector 1 and try to erase it
+	if err := d.SetCCFG(ccboot.ID_SECTOR_PROT, 0xFFFFFFFD); err != nil {
+		t.Fatalf("Error setting CCFG: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_SUCCESS)
+	if err := d.SectorErase(0x1000); err != nil {
+		t.Fatalf("Error erasing sector: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_FLASH_FAIL)
+}
+
+func TestUnknownCommand(t *testing.T) {
+	d, _ := newSyncedDevice(t)
+	if err := d.SendPacket([]byte{0x03, 0x7F, 0x7F}); err != nil {
+		t.Fatalf("Error sending packet: %v", err)
+	}
+	expectStatus(t, d, ccboot.COMMAND_RET_UNKNOW_CMD)
+}
+
+func TestBadChecksum(t *testing.T) {
+	d, _ := newSyncedDevice(t)
+	// the simulator NACKs every attempt, so the device gives up
+	err := d.SendPacket([]byte{0x03, 0x00, byte(ccboot.COMMAND_PING)})
+	if !errors.Is(err, ccboot.ErrDevice) {
+		t.Fatalf("Sending a corrupt packet returned %v, expected %v", err, ccboot.ErrDevice)
+	}
+	var cmdErr *ccboot.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Reason != ccboot.ReasonNack || cmdErr.Command != ccboot.COMMAND_PING {
+		t.Errorf("Sending a corrupt packet returned %v, expected a NACKed COMMAND_PING", err)
+	}
+	if err := d.Ping(); err != nil {
+		t.Fatalf("Error pinging after corrupt packet: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package ccboottest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A session log is a text file with one line per port operation.
+// Lines starting with '>' hold bytes written by the host, lines starting
+// with '<' hold bytes returned by a single read. Blank lines and lines
+// starting with '#' are ignored.
+//
+//	# CC2650 ping
+//	> 5555
+//	< 00cc
+//	> 032020
+//	< 00cc
+
+const (
+	logWrite = '>'
+	logRead  = '<'
+)
+
+// ErrReplayFinished is returned by Write when the host sends bytes after
+// the end of the recorded session
+var ErrReplayFinished = errors.New("Replay has no more recorded writes")
+
+// Recorder wraps a port and logs every byte exchanged through it, so that
+// the session can later be played back with a Replay
+type Recorder struct {
+	mu   sync.Mutex
+	port io.ReadWriteCloser
+	log  io.Writer
+	err  error
+}
+
+// NewRecorder creates a Recorder that passes all reads and writes
+// through to port and logs them to log
+func NewRecorder(port io.ReadWriteCloser, log io.Writer) *Recorder {
+	return &Recorder{port: port, log: log}
+}
+
+func (r *Recorder) Read(p []byte) (int, error) {
+	n, err := r.port.Read(p)
+	if n > 0 {
+		r.record(logRead, p[:n])
+	}
+	return n, err
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	n, err := r.port.Write(p)
+	if n > 0 {
+		r.record(logWrite, p[:n])
+	}
+	return n, err
+}
+
+// Close closes the underlying port
+func (r *Recorder) Close() error {
+	return r.port.Close()
+}
+
+// Err returns the first error encountered while writing the log
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(dir byte, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	_, r.err = fmt.Fprintf(r.log, "%c %s\n", dir, hex.EncodeToString(data))
+}
+
+// logEntry is one recorded read or write
+type logEntry struct {
+	line int
+	dir  byte
+	data []byte
+}
+
+// MismatchError reports that the host wrote different bytes than were
+// recorded
+type MismatchError struct {
+	// Line is the line of the session log holding the expected bytes
+	Line     int
+	Expected []byte
+	Got      []byte
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("replay mismatch at line %d: sent %s, expected %s", e.Line, hex.EncodeToString(e.Got), hex.EncodeToString(e.Expected))
+}
+
+// Replay plays back a recorded session as a port. Reads return the
+// recorded device bytes once the host has written everything that
+// preceded them, and writes must match the recorded host bytes exactly.
+type Replay struct {
+	// ReadTimeout is how long Read blocks when the next recorded
+	// operation is not a read, emulating the read timeout of a serial port
+	ReadTimeout time.Duration
+
+	mu      sync.Mutex
+	entries []logEntry
+	pos     int // index of the current entry
+	off     int // bytes of the current entry already consumed
+	err     error
+	closed  bool
+}
+
+// NewReplay parses a session log written by a Recorder
+func NewReplay(log io.Reader) (*Replay, error) {
+	r := &Replay{ReadTimeout: time.Millisecond}
+	scanner := bufio.NewScanner(log)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+		dir := text[0]
+		if dir != logWrite && dir != logRead {
+			return nil, fmt.Errorf("line %d: unknown direction %q", line, dir)
+		}
+		data, err := hex.DecodeString(strings.TrimSpace(text[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		r.entries = append(r.entries, logEntry{line, dir, data})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Read returns the next recorded device bytes. If the host has not yet
+// written everything recorded before them, it waits ReadTimeout and
+// returns 0 bytes.
+func (r *Replay) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	if !r.closed && (r.pos >= len(r.entries) || r.entries[r.pos].dir != logRead) {
+		r.mu.Unlock()
+		time.Sleep(r.ReadTimeout)
+		return 0, r.closedErr()
+	}
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	e := r.entries[r.pos]
+	n := copy(p, e.data[r.off:])
+	r.advance(n)
+	return n, nil
+}
+
+// Write checks p against the recorded host bytes. On a mismatch it
+// returns a *MismatchError, and every later Write fails with the same
+// error.
+func (r *Replay) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	for written := 0; written < len(p); {
+		// any device bytes the host did not read are skipped, since a
+		// real port would have discarded or buffered them
+		for r.pos < len(r.entries) && r.entries[r.pos].dir == logRead {
+			r.advance(len(r.entries[r.pos].data) - r.off)
+		}
+		if r.pos >= len(r.entries) {
+			r.err = ErrReplayFinished
+			return written, r.err
+		}
+		e := r.entries[r.pos]
+		expected := e.data[r.off:]
+		got := p[written:]
+		if len(got) > len(expected) {
+			got = got[:len(expected)]
+		}
+		if !bytes.Equal(got, expected[:len(got)]) {
+			r.err = &MismatchError{Line: e.line, Expected: expected, Got: p[written:]}
+			return written, r.err
+		}
+		written += len(got)
+		r.advance(len(got))
+	}
+	return len(p), nil
+}
+
+// Close closes the replay port
+func (r *Replay) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+// Err returns the mismatch that stopped the replay, or an error if the
+// host has not yet written all of the recorded bytes
+func (r *Replay) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	for i := r.pos; i < len(r.entries); i++ {
+		if r.entries[i].dir == logWrite {
+			return fmt.Errorf("replay incomplete: host never sent the bytes recorded at line %d", r.entries[i].line)
+		}
+	}
+	return nil
+}
+
+func (r *Replay) closedErr() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return io.ErrClosedPipe
+	}
+	return nil
+}
+
+// advance consumes n bytes of the current entry
+func (r *Replay) advance(n int) {
+	r.off += n
+	if r.off >= len(r.entries[r.pos].data) {
+		r.pos++
+		r.off = 0
+	}
+}
@@ -0,0 +1,101 @@
+package ccboottest
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+)
+
+// recordSession runs fn against a simulated bootloader and returns the
+// recorded session log
+func recordSession(t *testing.T, fn func(d *ccboot.Device) error) string {
+	t.Helper()
+	var log bytes.Buffer
+	r := NewRecorder(NewBootloader(DefaultConfig), &log)
+	if err := fn(ccboot.NewDevice(r)); err != nil {
+		t.Fatalf("Error recording session: %v", err)
+	}
+	if err := r.Err(); err != nil {
+		t.Fatalf("Error writing session log: %v", err)
+	}
+	return log.String()
+}
+
+func readChipID(d *ccboot.Device) error {
+	if err := d.Sync(); err != nil {
+		return err
+	}
+	if _, err := d.GetChipID(); err != nil {
+		return err
+	}
+	return d.Ping()
+}
+
+func TestRecordReplay(t *testing.T) {
+	log := recordSession(t, readChipID)
+	if !strings.HasPrefix(log, "> 5555\n< 00cc\n") {
+		t.Fatalf("Session log does not start with the sync exchange:\n%s", log)
+	}
+
+	replay, err := NewReplay(strings.NewReader("# chip id\n" + log))
+	if err != nil {
+		t.Fatalf("Error parsing session log: %v", err)
+	}
+	d := ccboot.NewDevice(replay)
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to replay: %v", err)
+	}
+	id, err := d.GetChipID()
+	if err != nil {
+		t.Fatalf("Error reading chip id: %v", err)
+	}
+	if id != DefaultConfig.ChipID {
+		t.Errorf("Chip ID is 0x%X, expected 0x%X", id, DefaultConfig.ChipID)
+	}
+	if err := replay.Err(); err == nil {
+		t.Error("Replay reported completion before the final ping")
+	}
+	if err := d.Ping(); err != nil {
+		t.Fatalf("Error pinging: %v", err)
+	}
+	if err := replay.Err(); err != nil {
+		t.Errorf("Replay did not complete: %v", err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	log := recordSession(t, readChipID)
+	replay, err := NewReplay(strings.NewReader(log))
+	if err != nil {
+		t.Fatalf("Error parsing session log: %v", err)
+	}
+	d := ccboot.NewDevice(replay)
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to replay: %v", err)
+	}
+
+	// the recorded session asked for the chip ID instead
+	err = d.Ping()
+	var mismatch *MismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Ping returned %v, expected a *MismatchError", err)
+	}
+	if mismatch.Line != 3 {
+		t.Errorf("Mismatch reported at line %d, expected 3", mismatch.Line)
+	}
+	if err := replay.Err(); err != mismatch {
+		t.Errorf("Replay error is %v, expected %v", err, mismatch)
+	}
+}
+
+func TestReplayBadLog(t *testing.T) {
+	if _, err := NewReplay(strings.NewReader("> 5555\n? 00cc\n")); err == nil {
+		t.Error("Parsed a log with an unknown direction")
+	}
+	if _, err := NewReplay(strings.NewReader("> 55x5\n")); err == nil {
+		t.Error("Parsed a log with bad hex")
+	}
+}
@@ -0,0 +1,269 @@
+package ccboot
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// CCFGSize is the size of the CCFG block at the end of flash
+const CCFGSize = 0x58
+
+// Offsets of the CCFG registers, relative to the start of the block
+const (
+	ccfgExtLFClk        = 0x00
+	ccfgModeConf1       = 0x04
+	ccfgSizeAndDisFlags = 0x08
+	ccfgModeConf        = 0x0C
+	ccfgVoltLoad0       = 0x10
+	ccfgVoltLoad1       = 0x14
+	ccfgRTCOffset       = 0x18
+	ccfgFreqOffset      = 0x1C
+	ccfgIEEEMAC0        = 0x20
+	ccfgIEEEMAC1        = 0x24
+	ccfgIEEEBLE0        = 0x28
+	ccfgIEEEBLE1        = 0x2C
+	ccfgBLConfig        = 0x30
+	ccfgEraseConf       = 0x34
+	ccfgTIOptions       = 0x38
+	ccfgTapDap0         = 0x3C
+	ccfgTapDap1         = 0x40
+	ccfgImageValidConf  = 0x44
+	ccfgProt31_0        = 0x48
+	ccfgProtWords       = 4
+	ccfgProtSectors     = 32 * ccfgProtWords
+)
+
+// CCFGEnable is an 8 bit CCFG field that enables a feature only when it
+// holds CCFGEnabled
+type CCFGEnable uint8
+
+const (
+	CCFGEnabled  = CCFGEnable(0xC5)
+	CCFGDisabled = CCFGEnable(0x00)
+)
+
+// Enabled reports whether the field enables its feature
+func (e CCFGEnable) Enabled() bool {
+	return e == CCFGEnabled
+}
+
+func (e CCFGEnable) String() string {
+	if e.Enabled() {
+		return "enabled"
+	}
+	return fmt.Sprintf("disabled (0x%.2X)", uint8(e))
+}
+
+// CCFG is the customer configuration block at the end of CC13xx/CC26xx
+// flash.
+//
+// Registers that are not decoded are kept whole. Reserved bits of the
+// decoded registers are not kept, and Marshal writes them as 1, their
+// erased value.
+type CCFG struct {
+	ExtLFClk        uint32
+	ModeConf1       uint32
+	SizeAndDisFlags uint32
+	ModeConf        uint32
+	VoltLoad0       uint32
+	VoltLoad1       uint32
+	RTCOffset       uint32
+	FreqOffset      uint32
+	// IEEEMAC and IEEEBLE override the factory MAC addresses unless they
+	// are all ones
+	IEEEMAC [2]uint32
+	IEEEBLE [2]uint32
+
+	// BL_CONFIG
+	BootloaderEnable CCFGEnable
+	// BackdoorLevel is the level of BackdoorPin that enters the
+	// bootloader, true for high
+	BackdoorLevel  bool
+	BackdoorPin    uint8
+	BackdoorEnable CCFGEnable
+
+	// ERASE_CONF, set when the erase is disabled
+	ChipEraseDisable bool
+	BankEraseDisable bool
+
+	// CCFG_TI_OPTIONS
+	TIFAEnable CCFGEnable
+
+	// CCFG_TAP_DAP_0 and CCFG_TAP_DAP_1
+	CPUDAPEnable    CCFGEnable
+	PRCMTAPEnable   CCFGEnable
+	TestTAPEnable   CCFGEnable
+	PBIST2TAPEnable CCFGEnable
+	PBIST1TAPEnable CCFGEnable
+	WUCTAPEnable    CCFGEnable
+
+	// ImageValid is IMAGE_VALID_CONF, which is 0 on a CC13x0/CC26x0 with
+	// a valid image, and the address of the image's vector table on a
+	// CC13x2/CC26x2
+	ImageValid uint32
+
+	// SectorProt is CCFG_PROT_31_0 through CCFG_PROT_127_96. A cleared
+	// bit write protects its sector.
+	SectorProt [ccfgProtWords]uint32
+}
+
+// ParseCCFG decodes a CCFG block of CCFGSize bytes
+func ParseCCFG(data []byte) (*CCFG, error) {
+	if len(data) != CCFGSize {
+		return nil, ErrParse
+	}
+	word := func(offset int) uint32 {
+		return binary.LittleEndian.Uint32(data[offset : offset+4])
+	}
+	c := &CCFG{
+		ExtLFClk:        word(ccfgExtLFClk),
+		ModeConf1:       word(ccfgModeConf1),
+		SizeAndDisFlags: word(ccfgSizeAndDisFlags),
+		ModeConf:        word(ccfgModeConf),
+		VoltLoad0:       word(ccfgVoltLoad0),
+		VoltLoad1:       word(ccfgVoltLoad1),
+		RTCOffset:       word(ccfgRTCOffset),
+		FreqOffset:      word(ccfgFreqOffset),
+		IEEEMAC:         [2]uint32{word(ccfgIEEEMAC0), word(ccfgIEEEMAC1)},
+		IEEEBLE:         [2]uint32{word(ccfgIEEEBLE0), word(ccfgIEEEBLE1)},
+		ImageValid:      word(ccfgImageValidConf),
+	}
+
+	blConfig := word(ccfgBLConfig)
+	c.BootloaderEnable = CCFGEnable(blConfig >> 24)
+	c.BackdoorLevel = blConfig&(1<<16) != 0
+	c.BackdoorPin = uint8(blConfig >> 8)
+	c.BackdoorEnable = CCFGEnable(blConfig)
+
+	// the erase bits are active low
+	eraseConf := word(ccfgEraseConf)
+	c.ChipEraseDisable = eraseConf&(1<<8) == 0
+	c.BankEraseDisable = eraseConf&(1<<0) == 0
+
+	c.TIFAEnable = CCFGEnable(word(ccfgTIOptions))
+
+	tapDap0 := word(ccfgTapDap0)
+	c.CPUDAPEnable = CCFGEnable(tapDap0 >> 16)
+	c.PRCMTAPEnable = CCFGEnable(tapDap0 >> 8)
+	c.TestTAPEnable = CCFGEnable(tapDap0)
+	tapDap1 := word(ccfgTapDap1)
+	c.PBIST2TAPEnable = CCFGEnable(tapDap1 >> 16)
+	c.PBIST1TAPEnable = CCFGEnable(tapDap1 >> 8)
+	c.WUCTAPEnable = CCFGEnable(tapDap1)
+
+	for i := range c.SectorProt {
+		c.SectorProt[i] = word(ccfgProt31_0 + 4*i)
+	}
+	return c, nil
+}
+
+// Marshal encodes c as a CCFG block of CCFGSize bytes
+func (c *CCFG) Marshal() []byte {
+	data := make([]byte, CCFGSize)
+	put := func(offset int, v uint32) {
+		binary.LittleEndian.PutUint32(data[offset:offset+4], v)
+	}
+	put(ccfgExtLFClk, c.ExtLFClk)
+	put(ccfgModeConf1, c.ModeConf1)
+	put(ccfgSizeAndDisFlags, c.SizeAndDisFlags)
+	put(ccfgModeConf, c.ModeConf)
+	put(ccfgVoltLoad0, c.VoltLoad0)
+	put(ccfgVoltLoad1, c.VoltLoad1)
+	put(ccfgRTCOffset, c.RTCOffset)
+	put(ccfgFreqOffset, c.FreqOffset)
+	put(ccfgIEEEMAC0, c.IEEEMAC[0])
+	put(ccfgIEEEMAC1, c.IEEEMAC[1])
+	put(ccfgIEEEBLE0, c.IEEEBLE[0])
+	put(ccfgIEEEBLE1, c.IEEEBLE[1])
+
+	blConfig := uint32(c.BootloaderEnable)<<24 | 0xFE<<16 | uint32(c.BackdoorPin)<<8 | uint32(c.BackdoorEnable)
+	if c.BackdoorLevel {
+		blConfig |= 1 << 16
+	}
+	put(ccfgBLConfig, blConfig)
+
+	eraseConf := uint32(0xFFFFFFFF)
+	if c.ChipEraseDisable {
+		eraseConf &^= 1 << 8
+	}
+	if c.BankEraseDisable {
+		eraseConf &^= 1 << 0
+	}
+	put(ccfgEraseConf, eraseConf)
+
+	put(ccfgTIOptions, 0xFFFFFF00|uint32(c.TIFAEnable))
+	put(ccfgTapDap0, 0xFF000000|uint32(c.CPUDAPEnable)<<16|uint32(c.PRCMTAPEnable)<<8|uint32(c.TestTAPEnable))
+	put(ccfgTapDap1, 0xFF000000|uint32(c.PBIST2TAPEnable)<<16|uint32(c.PBIST1TAPEnable)<<8|uint32(c.WUCTAPEnable))
+	put(ccfgImageValidConf, c.ImageValid)
+	for i, v := range c.SectorProt {
+		put(ccfgProt31_0+4*i, v)
+	}
+	return data
+}
+
+// Protected reports whether sector is write protected
+func (c *CCFG) Protected(sector int) bool {
+	if sector < 0 || sector >= ccfgProtSectors {
+		return false
+	}
+	return c.SectorProt[sector/32]&(1<<uint(sector%32)) == 0
+}
+
+func (c *CCFG) String() string {
+	var b strings.Builder
+	level := "low"
+	if c.BackdoorLevel {
+		level = "high"
+	}
+	fmt.Fprintf(&b, "Bootloader:         %v\n", c.BootloaderEnable)
+	fmt.Fprintf(&b, "Backdoor:           %v, DIO%d active %s\n", c.BackdoorEnable, c.BackdoorPin, level)
+	fmt.Fprintf(&b, "Chip erase:         %s\n", allowed(!c.ChipEraseDisable))
+	fmt.Fprintf(&b, "Bank erase:         %s\n", allowed(!c.BankEraseDisable))
+	fmt.Fprintf(&b, "TI FA:              %v\n", c.TIFAEnable)
+	fmt.Fprintf(&b, "CPU DAP:            %v\n", c.CPUDAPEnable)
+	fmt.Fprintf(&b, "PRCM TAP:           %v\n", c.PRCMTAPEnable)
+	fmt.Fprintf(&b, "Test TAP:           %v\n", c.TestTAPEnable)
+	fmt.Fprintf(&b, "PBIST2 TAP:         %v\n", c.PBIST2TAPEnable)
+	fmt.Fprintf(&b, "PBIST1 TAP:         %v\n", c.PBIST1TAPEnable)
+	fmt.Fprintf(&b, "WUC TAP:            %v\n", c.WUCTAPEnable)
+	fmt.Fprintf(&b, "Image valid:        0x%.8X\n", c.ImageValid)
+
+	var prot []string
+	for i := 0; i < ccfgProtSectors; i++ {
+		if c.Protected(i) {
+			prot = append(prot, fmt.Sprint(i))
+		}
+	}
+	if len(prot) == 0 {
+		prot = []string{"none"}
+	}
+	fmt.Fprintf(&b, "Protected sectors:  %s\n", strings.Join(prot, " "))
+
+	fmt.Fprintf(&b, "EXT_LF_CLK:         0x%.8X\n", c.ExtLFClk)
+	fmt.Fprintf(&b, "MODE_CONF_1:        0x%.8X\n", c.ModeConf1)
+	fmt.Fprintf(&b, "SIZE_AND_DIS_FLAGS: 0x%.8X\n", c.SizeAndDisFlags)
+	fmt.Fprintf(&b, "MODE_CONF:          0x%.8X\n", c.ModeConf)
+	fmt.Fprintf(&b, "VOLT_LOAD:          0x%.8X 0x%.8X\n", c.VoltLoad0, c.VoltLoad1)
+	fmt.Fprintf(&b, "RTC_OFFSET:         0x%.8X\n", c.RTCOffset)
+	fmt.Fprintf(&b, "FREQ_OFFSET:        0x%.8X\n", c.FreqOffset)
+	fmt.Fprintf(&b, "IEEE_MAC:           0x%.8X 0x%.8X\n", c.IEEEMAC[0], c.IEEEMAC[1])
+	fmt.Fprintf(&b, "IEEE_BLE:           0x%.8X 0x%.8X\n", c.IEEEBLE[0], c.IEEEBLE[1])
+	return b.String()
+}
+
+func allowed(ok bool) string {
+	if ok {
+		return "allowed"
+	}
+	return "disabled"
+}
+
+// ReadCCFG reads and decodes the CCFG block at address
+func (d *Device) ReadCCFG(address uint32) (*CCFG, error) {
+	data, err := d.ReadMemory(address, CCFGSize)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCCFG(data)
+}
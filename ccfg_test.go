@@ -0,0 +1,105 @@
+package ccboot_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+	"github.com/openchirp/ccboot/ccboottest"
+)
+
+// defaultCCFG is the CCFG of TI's ccfg.c with the backdoor enabled on
+// DIO13, active low
+const defaultCCFG = "" +
+	"fffffffff09ffffd0000ffff0000f3ff" +
+	"ffffffffffffffff00000000ffffffff" +
+	"ffffffffffffffffffffffffffffffff" +
+	"c50dfec5ffffffffc5ffffffc5c5c5ff" +
+	"c5c5c5ff00000000ffffffffffffffff" +
+	"ffffffffffffffff"
+
+// unlockedCCFG returns defaultCCFG, which keeps the bootloader and the
+// debug port enabled
+func unlockedCCFG() []byte {
+	raw, _ := hex.DecodeString(defaultCCFG)
+	return raw
+}
+
+func TestParseCCFG(t *testing.T) {
+	raw := unlockedCCFG()
+	c, err := ccboot.ParseCCFG(raw)
+	if err != nil {
+		t.Fatalf("Error parsing CCFG: %v", err)
+	}
+	if !c.BootloaderEnable.Enabled() || !c.BackdoorEnable.Enabled() {
+		t.Errorf("Bootloader is %v and backdoor is %v, expected both enabled", c.BootloaderEnable, c.BackdoorEnable)
+	}
+	if c.BackdoorPin != 13 || c.BackdoorLevel {
+		t.Errorf("Backdoor is DIO%d level %v, expected DIO13 low", c.BackdoorPin, c.BackdoorLevel)
+	}
+	if c.ChipEraseDisable || c.BankEraseDisable {
+		t.Error("Erase is disabled, expected it allowed")
+	}
+	if !c.CPUDAPEnable.Enabled() || !c.WUCTAPEnable.Enabled() {
+		t.Errorf("CPU DAP is %v and WUC TAP is %v, expected both enabled", c.CPUDAPEnable, c.WUCTAPEnable)
+	}
+	if c.ImageValid != 0 || c.Protected(0) {
+		t.Errorf("Image valid is 0x%X and sector 0 protection is %v", c.ImageValid, c.Protected(0))
+	}
+	if got := c.Marshal(); !bytes.Equal(got, raw) {
+		t.Errorf("Marshal returned\n%x, expected\n%x", got, raw)
+	}
+	if !strings.Contains(c.String(), "Backdoor:           enabled, DIO13 active low") {
+		t.Errorf("CCFG dump does not describe the backdoor:\n%v", c)
+	}
+
+	if _, err := ccboot.ParseCCFG(raw[1:]); !errors.Is(err, ccboot.ErrParse) {
+		t.Errorf("ParseCCFG of a short block returned %v, expected %v", err, ccboot.ErrParse)
+	}
+}
+
+func TestReadCCFG(t *testing.T) {
+	d, _ := newSimDevice(t, ccboottest.DefaultConfig)
+	fields := []struct {
+		id    ccboot.CCFG_FieldID
+		value uint32
+	}{
+		{ccboot.ID_BL_ENABLE, 0xC5},
+		{ccboot.ID_BL_BACKDOOR_EN, 0xC5},
+		{ccboot.ID_BL_BACKDOOR_PIN, 11},
+		{ccboot.ID_BL_BACKDOOR_LEVEL, 0},
+		{ccboot.ID_CPU_DAP_LCK, 0x00},
+		{ccboot.ID_PRCM_TAP_LCK, 0xC5},
+		{ccboot.ID_CHIP_ERASE_DIS, 0},
+		{ccboot.ID_SECTOR_PROT, 0xFFFFFFFE},
+		{ccboot.ID_IMAGE_VALID, 0},
+	}
+	for _, f := range fields {
+		if err := d.SetCCFG(f.id, f.value); err != nil {
+			t.Fatalf("Error setting %v: %v", f.id, err)
+		}
+	}
+
+	c, err := d.ReadCCFG(0x20000 - ccboot.CCFGSize)
+	if err != nil {
+		t.Fatalf("Error reading CCFG: %v", err)
+	}
+	if !c.BootloaderEnable.Enabled() || !c.BackdoorEnable.Enabled() || c.BackdoorPin != 11 || c.BackdoorLevel {
+		t.Errorf("Bootloader config is wrong:\n%v", c)
+	}
+	if c.CPUDAPEnable.Enabled() || !c.PRCMTAPEnable.Enabled() {
+		t.Errorf("CPU DAP is %v and PRCM TAP is %v, expected only the CPU DAP disabled", c.CPUDAPEnable, c.PRCMTAPEnable)
+	}
+	if !c.ChipEraseDisable || c.BankEraseDisable {
+		t.Errorf("Chip erase disable is %v and bank erase disable is %v, expected true and false", c.ChipEraseDisable, c.BankEraseDisable)
+	}
+	if !c.Protected(0) || c.Protected(1) {
+		t.Errorf("Sector protection is 0x%.8X, expected only sector 0", c.SectorProt[0])
+	}
+	if !strings.Contains(c.String(), "Protected sectors:  0\n") {
+		t.Errorf("CCFG dump does not list the protected sector:\n%v", c)
+	}
+}
@@ -0,0 +1,24 @@
+package ccboot
+
+import (
+	"hash/crc32"
+)
+
+// ChecksumCRC32 computes the CRC32 that COMMAND_CRC32 returns for a
+// region holding data, when each location is read rcount additional
+// times. The bootloader feeds every read into an IEEE 802.3 CRC32, so
+// each byte is counted rcount+1 times.
+func ChecksumCRC32(data []byte, rcount uint32) uint32 {
+	if rcount == 0 {
+		return crc32.ChecksumIEEE(data)
+	}
+	crc := uint32(0)
+	buf := make([]byte, rcount+1)
+	for _, v := range data {
+		for i := range buf {
+			buf[i] = v
+		}
+		crc = crc32.Update(crc, crc32.IEEETable, buf)
+	}
+	return crc
+}
@@ -0,0 +1,322 @@
+package ccboot_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openchirp/ccboot"
+	"github.com/openchirp/ccboot/ccboottest"
+)
+
+func newSimDevice(t *testing.T, cfg ccboottest.Config) (*ccboot.Device, *ccboottest.Bootloader) {
+	t.Helper()
+	b := ccboottest.NewBootloader(cfg)
+	d := ccboot.NewDevice(b)
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+	return d, b
+}
+
+func TestContextCanceled(t *testing.T) {
+	d, _ := newSimDevice(t, ccboottest.DefaultConfig)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := d.WithContext(ctx).Ping(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Ping with canceled context returned %v, expected %v", err, context.Canceled)
+	}
+	// the original device is unaffected
+	if err := d.Ping(); err != nil {
+		t.Fatalf("Error pinging: %v", err)
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	cfg := ccboottest.DefaultConfig
+	cfg.ReadTimeout = 10 * time.Millisecond
+	// never synced, so the simulator ignores every packet
+	d := ccboot.NewDevice(ccboottest.NewBootloader(cfg))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := d.WithContext(ctx).Ping()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Ping returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Ping took %v to notice the deadline", elapsed)
+	}
+}
+
+func TestContextDownloadAborted(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	ctx, cancel := context.WithCancel(context.Background())
+	dc := d.WithContext(ctx)
+
+	if err := dc.Download(0x1000, 8); err != nil {
+		t.Fatalf("Error sending download: %v", err)
+	}
+	if err := dc.SendData([]byte{1, 2, 3, 4}); err != nil {
+		t.Fatalf("Error sending data: %v", err)
+	}
+	cancel()
+	err := dc.SendData([]byte{5, 6, 7, 8})
+	if !errors.Is(err, ccboot.ErrDownloadAborted) {
+		t.Errorf("Interrupted download returned %v, expected %v", err, ccboot.ErrDownloadAborted)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Interrupted download returned %v, expected %v", err, context.Canceled)
+	}
+
+	// the device is still usable and the download has been terminated
+	if err := d.Ping(); err != nil {
+		t.Fatalf("Error pinging after abort: %v", err)
+	}
+	if err := d.SendData([]byte{5, 6, 7, 8}); err != nil {
+		t.Fatalf("Error sending data: %v", err)
+	}
+	if status, err := d.GetStatus(); err != nil || status != ccboot.COMMAND_RET_INVALID_CMD {
+		t.Errorf("GetStatus returned %v, %v, expected %v", status, err, ccboot.COMMAND_RET_INVALID_CMD)
+	}
+	if got := b.Flash()[0x1004]; got != 0xFF {
+		t.Errorf("Flash byte after aborted download is 0x%.2X, expected 0xFF", got)
+	}
+}
+
+// heldPort withholds the simulator's responses while hold is set
+type heldPort struct {
+	*ccboottest.Bootloader
+	hold bool
+}
+
+func (p *heldPort) Read(buf []byte) (int, error) {
+	if p.hold {
+		time.Sleep(time.Millisecond)
+		return 0, nil
+	}
+	return p.Bootloader.Read(buf)
+}
+
+func TestContextDrainTraced(t *testing.T) {
+	var frames []ccboot.Frame
+	port := &heldPort{Bootloader: ccboottest.NewBootloader(ccboottest.DefaultConfig)}
+	d := ccboot.NewDeviceWithOptions(port, ccboot.Options{
+		ResponseTimeout: time.Second,
+		Tracer: ccboot.TracerFunc(func(f ccboot.Frame) {
+			frames = append(frames, f)
+		}),
+	})
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+
+	// the ping is ACKed, but the ACK is not read before the deadline
+	port.hold = true
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if err := d.WithContext(ctx).Ping(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Ping returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	port.hold = false
+	frames = nil
+	if err := d.Ping(); err != nil {
+		t.Fatalf("Error pinging: %v", err)
+	}
+	if len(frames) == 0 || frames[0].Dir != ccboot.FrameReceived || frames[0].Kind != ccboot.FrameDiscarded ||
+		!bytes.Equal(frames[0].Bytes, []byte{0x00, ccboot.CC_ACK}) {
+		t.Errorf("Frames after the abandoned ping are %v, expected the stale ACK to be discarded first", frames)
+	}
+}
+
+func TestOptionsRetryBackoff(t *testing.T) {
+	// never synced, so the simulator ignores every packet
+	d := ccboot.NewDeviceWithOptions(ccboottest.NewBootloader(ccboottest.DefaultConfig), ccboot.Options{
+		SendAttempts: 3,
+		ReadAttempts: 1,
+		Backoff:      10 * time.Millisecond,
+		MaxBackoff:   15 * time.Millisecond,
+	})
+
+	start := time.Now()
+	if err := d.Ping(); !errors.Is(err, ccboot.ErrDevice) {
+		t.Fatalf("Ping returned %v, expected %v", err, ccboot.ErrDevice)
+	}
+	// two retries, backing off 10ms and then 15ms
+	if elapsed := time.Since(start); elapsed < 25*time.Millisecond {
+		t.Errorf("Ping gave up after %v, expected at least 25ms of backoff", elapsed)
+	}
+}
+
+func TestOptionsCommandTimeout(t *testing.T) {
+	d := ccboot.NewDeviceWithOptions(ccboottest.NewBootloader(ccboottest.DefaultConfig), ccboot.Options{
+		SendAttempts: 1,
+		CommandTimeouts: map[ccboot.CommandType]time.Duration{
+			ccboot.COMMAND_PING: 30 * time.Millisecond,
+		},
+	})
+
+	start := time.Now()
+	if err := d.Ping(); !errors.Is(err, ccboot.ErrDevice) {
+		t.Fatalf("Ping returned %v, expected %v", err, ccboot.ErrDevice)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Ping gave up after %v, expected to wait for 30ms", elapsed)
+	}
+}
+
+func TestOptionsCommandTimeoutDefaults(t *testing.T) {
+	defaults := ccboot.DefaultOptions.CommandTimeouts
+	defer func() { ccboot.DefaultOptions.CommandTimeouts = defaults }()
+	ccboot.DefaultOptions.CommandTimeouts = map[ccboot.CommandType]time.Duration{
+		ccboot.COMMAND_PING: 30 * time.Millisecond,
+	}
+	d := ccboot.NewDeviceWithOptions(ccboottest.NewBootloader(ccboottest.DefaultConfig), ccboot.Options{
+		SendAttempts: 1,
+		CommandTimeouts: map[ccboot.CommandType]time.Duration{
+			ccboot.COMMAND_CRC32: time.Millisecond,
+		},
+	})
+	// the device keeps its own copy of the defaults
+	ccboot.DefaultOptions.CommandTimeouts[ccboot.COMMAND_PING] = time.Millisecond
+
+	start := time.Now()
+	if err := d.Ping(); !errors.Is(err, ccboot.ErrDevice) {
+		t.Fatalf("Ping returned %v, expected %v", err, ccboot.ErrDevice)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Ping gave up after %v, expected the default wait of 30ms", elapsed)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var frames []ccboot.Frame
+	d := ccboot.NewDeviceWithOptions(ccboottest.NewBootloader(ccboottest.DefaultConfig), ccboot.Options{
+		Tracer: ccboot.TracerFunc(func(f ccboot.Frame) {
+			frames = append(frames, f)
+		}),
+	})
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+	if _, err := d.GetStatus(); err != nil {
+		t.Fatalf("Error getting status: %v", err)
+	}
+
+	expected := []struct {
+		dir  ccboot.FrameDir
+		kind ccboot.FrameKind
+		info string
+	}{
+		{ccboot.FrameSent, ccboot.FrameSync, "SYNC"},
+		{ccboot.FrameReceived, ccboot.FrameSync, "SYNC response"},
+		{ccboot.FrameSent, ccboot.FramePacket, "COMMAND_GET_STATUS"},
+		{ccboot.FrameReceived, ccboot.FrameAck, "ACK"},
+		{ccboot.FrameReceived, ccboot.FramePacket, "COMMAND_GET_STATUS response (status=SUCCESS)"},
+		{ccboot.FrameSent, ccboot.FrameAck, "ACK"},
+	}
+	if len(frames) != len(expected) {
+		t.Fatalf("Traced %d frames, expected %d: %v", len(frames), len(expected), frames)
+	}
+	for i, e := range expected {
+		f := frames[i]
+		if f.Dir != e.dir || f.Kind != e.kind || f.String() != e.info {
+			t.Errorf("Frame %d is %v %v %q, expected %v %v %q", i, f.Dir, f.Kind, f.String(), e.dir, e.kind, e.info)
+		}
+		if f.Time.IsZero() {
+			t.Errorf("Frame %d has no timestamp", i)
+		}
+	}
+}
+
+func TestTextAndJSONTracer(t *testing.T) {
+	var text, jsonl bytes.Buffer
+	for _, tracer := range []ccboot.Tracer{ccboot.NewTextTracer(&text), ccboot.NewJSONTracer(&jsonl)} {
+		d := ccboot.NewDeviceWithOptions(ccboottest.NewBootloader(ccboottest.DefaultConfig), ccboot.Options{Tracer: tracer})
+		if err := d.Sync(); err != nil {
+			t.Fatalf("Error syncing to simulator: %v", err)
+		}
+		if err := d.Download(0x1000, 4); err != nil {
+			t.Fatalf("Error sending download: %v", err)
+		}
+	}
+
+	if !strings.Contains(text.String(), "COMMAND_DOWNLOAD (addr=0x00001000, size=4)") {
+		t.Errorf("Text trace does not describe the download:\n%s", text.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(jsonl.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("JSON trace has %d lines, expected 4:\n%s", len(lines), jsonl.String())
+	}
+	var f struct {
+		Dir   string
+		Kind  string
+		Bytes string
+		Info  string
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &f); err != nil {
+		t.Fatalf("Error decoding JSON trace line: %v", err)
+	}
+	if f.Dir != "tx" || f.Kind != "packet" || f.Bytes != "0b35210000100000000004" {
+		t.Errorf("JSON trace line is %+v", f)
+	}
+}
+
+func TestCommandErrorTimeout(t *testing.T) {
+	// never synced, so the simulator ignores every packet
+	d := ccboot.NewDevice(ccboottest.NewBootloader(ccboottest.DefaultConfig))
+
+	err := d.Ping()
+	var cmdErr *ccboot.CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Ping returned %v, expected a *CommandError", err)
+	}
+	if cmdErr.Command != ccboot.COMMAND_PING || cmdErr.Reason != ccboot.ReasonTimeout || cmdErr.Attempts != ccboot.DefaultOptions.SendAttempts {
+		t.Errorf("Ping returned %+v", cmdErr)
+	}
+	if !errors.Is(err, ccboot.ErrDevice) || !errors.Is(err, ccboot.ErrDeviceTimeout) {
+		t.Errorf("Ping returned %v, expected it to match %v and %v", err, ccboot.ErrDevice, ccboot.ErrDeviceTimeout)
+	}
+	if errors.Is(err, ccboot.ErrBadPacket) {
+		t.Errorf("Ping returned %v, which should not match %v", err, ccboot.ErrBadPacket)
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	d := ccboot.NewDeviceWithOptions(ccboottest.NewBootloader(ccboottest.DefaultConfig), ccboot.Options{CheckStatus: true})
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+
+	if err := d.Download(0x1000, 4); err != nil {
+		t.Fatalf("Error sending download: %v", err)
+	}
+	if err := d.SendData([]byte{1, 2, 3, 4}); err != nil {
+		t.Fatalf("Error sending data: %v", err)
+	}
+
+	// not word aligned
+	err := d.Download(0x1002, 4)
+	var cmdErr *ccboot.CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Download returned %v, expected a *CommandError", err)
+	}
+	if cmdErr.Command != ccboot.COMMAND_DOWNLOAD || cmdErr.Reason != ccboot.ReasonStatus || cmdErr.Status != ccboot.COMMAND_RET_INVALID_ADR {
+		t.Errorf("Download returned %+v", cmdErr)
+	}
+	if !errors.Is(err, ccboot.ErrDevice) {
+		t.Errorf("Download returned %v, expected it to match %v", err, ccboot.ErrDevice)
+	}
+
+	err = d.SendData([]byte{1, 2, 3, 4})
+	if !errors.As(err, &cmdErr) || cmdErr.Command != ccboot.COMMAND_SEND_DATA || cmdErr.Status != ccboot.COMMAND_RET_INVALID_CMD {
+		t.Errorf("SendData without a download returned %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+// Package devicedb describes CC bootloader devices, such as their flash
+// layout and the addresses of important sections, in the JSON format of
+// ccboot's config.json.
+//
+// The format allows // comments and writes addresses as hex strings:
+//
+//	{
+//	    "devices": {
+//	        "CC2650F128": {
+//	            "chipid": "0x8002F000",
+//	            "sections": {
+//	                ".ccfg": {"addr":"0x1FFA8", "len":"0x58"} // CCFG block
+//	            },
+//	            "flashstart": "0x00",
+//	            "flashlength": "0x00020000"
+//	        }
+//	    }
+//	}
+package devicedb
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/openchirp/ccboot"
+)
+
+var ErrUnknownChip = errors.New("No device matches the chip ID")
+
+//go:embed devices.json
+var builtin []byte
+
+// Hex is a 32 bit value written as a hex string such as "0x1FFA8" or
+// "0001FFA8", with or without the 0x prefix. Plain JSON numbers are
+// accepted too, and are decimal.
+type Hex uint32
+
+func (h *Hex) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		// not a string, so it must be a number
+		var v uint32
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("invalid address %s", data)
+		}
+		*h = Hex(v)
+		return nil
+	}
+	digits := strings.TrimPrefix(strings.TrimPrefix(str, "0x"), "0X")
+	v, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil {
+		return fmt.Errorf("invalid address %q", str)
+	}
+	*h = Hex(v)
+	return nil
+}
+
+func (h Hex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.String())
+}
+
+func (h Hex) String() string {
+	return fmt.Sprintf("0x%X", uint32(h))
+}
+
+// Section is a region of memory, such as .ccfg
+type Section struct {
+	Addr Hex `json:"addr"`
+	Len  Hex `json:"len"`
+}
+
+// Variable is a named register or configuration word
+type Variable struct {
+	Addr    Hex `json:"addr"`
+	BitSize int `json:"bitsize"`
+}
+
+// Device describes one part
+type Device struct {
+	// Name is the key of the device in the database
+	Name string `json:"-"`
+	// Family is the group of parts that share a bootloader, such as
+	// "CC2538" or "CC26x2"
+	Family string `json:"family,omitempty"`
+	ChipID Hex    `json:"chipid,omitempty"`
+	// ChipIDMask selects the bits of the chip ID that identify the part,
+	// so that revision bits can be ignored. Zero means all bits.
+	ChipIDMask Hex `json:"chipidmask,omitempty"`
+	// WaferID is the die's ID from the CC13xx/CC26xx ICEPICK_DEVICE_ID
+	// register
+	WaferID     Hex                 `json:"waferid,omitempty"`
+	Sections    map[string]Section  `json:"sections,omitempty"`
+	Variables   map[string]Variable `json:"variables,omitempty"`
+	FlashStart  Hex                 `json:"flashstart"`
+	FlashLength Hex                 `json:"flashlength"`
+	SectorSize  Hex                 `json:"sectorsize,omitempty"`
+	RAMStart    Hex                 `json:"ramstart,omitempty"`
+	RAMLength   Hex                 `json:"ramlength,omitempty"`
+	// Commands names the bootloader commands the part supports. An empty
+	// list means that the supported commands are not known.
+	Commands []string `json:"commands,omitempty"`
+}
+
+// Geometry returns the flash layout of d
+func (d *Device) Geometry() ccboot.Geometry {
+	return ccboot.Geometry{
+		FlashBase:  uint32(d.FlashStart),
+		FlashSize:  uint32(d.FlashLength),
+		SectorSize: uint32(d.SectorSize),
+	}
+}
+
+// families maps the Family of a device to ccboot's
+var families = map[string]ccboot.Family{
+	"CC2538": ccboot.FamilyCC2538,
+	"CC26x0": ccboot.FamilyCC26x0,
+	"CC13x0": ccboot.FamilyCC13x0,
+	"CC26x2": ccboot.FamilyCC26x2,
+	"CC13x2": ccboot.FamilyCC26x2,
+}
+
+// Variant returns the bootloader variant of d, which can be set as
+// ccboot.Options.Variant
+func (d *Device) Variant() *ccboot.Variant {
+	return ccboot.VariantForFamily(families[d.Family])
+}
+
+// CCFG returns the location of the device's CCFG block, if it has one
+func (d *Device) CCFG() (Section, bool) {
+	s, ok := d.Sections[".ccfg"]
+	return s, ok
+}
+
+// Supports reports whether the bootloader of d accepts cmd. Commands
+// narrows the commands of the device's variant, and is ignored when
+// empty.
+func (d *Device) Supports(cmd ccboot.CommandType) bool {
+	if !d.Variant().Supports(cmd) {
+		return false
+	}
+	if len(d.Commands) == 0 {
+		return true
+	}
+	for _, name := range d.Commands {
+		if name == cmd.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether the value returned by GetChipID identifies d.
+// A device without a chip ID matches nothing.
+func (d *Device) Matches(chipID uint32) bool {
+	if d.ChipID == 0 && d.ChipIDMask == 0 {
+		return false
+	}
+	mask := uint32(d.ChipIDMask)
+	if mask == 0 {
+		mask = 0xFFFFFFFF
+	}
+	return chipID&mask == uint32(d.ChipID)&mask
+}
+
+// DB is a set of devices by name
+type DB struct {
+	Devices map[string]*Device `json:"devices"`
+}
+
+// Parse reads a device database, ignoring // comments
+func Parse(data []byte) (*DB, error) {
+	db := new(DB)
+	if err := json.Unmarshal(stripComments(data), db); err != nil {
+		return nil, err
+	}
+	if db.Devices == nil {
+		db.Devices = make(map[string]*Device)
+	}
+	for name, d := range db.Devices {
+		if d == nil {
+			return nil, fmt.Errorf("device %s is null", name)
+		}
+		d.Name = name
+		for _, str := range d.Commands {
+			cmd, err := ccboot.ParseCommandType(str)
+			if err != nil {
+				return nil, fmt.Errorf("device %s: unknown command %q", name, str)
+			}
+			if v := d.Variant(); !v.Supports(cmd) {
+				return nil, fmt.Errorf("device %s: %v is not supported by the %v bootloader", name, cmd, v)
+			}
+		}
+	}
+	return db, nil
+}
+
+// Load reads the device database in the file at path
+func Load(path string) (*DB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// Default returns a new copy of the built-in device database
+func Default() *DB {
+	db, err := Parse(builtin)
+	if err != nil {
+		panic("devicedb: bad built-in database: " + err.Error())
+	}
+	return db
+}
+
+// Merge adds the devices of other to db, replacing those with the same
+// name
+func (db *DB) Merge(other *DB) {
+	for name, d := range other.Devices {
+		db.Devices[name] = d
+	}
+}
+
+// Lookup returns the device with the given name
+func (db *DB) Lookup(name string) (*Device, bool) {
+	d, ok := db.Devices[name]
+	return d, ok
+}
+
+// Identify returns the device described by info, as found by ccboot's
+// Device.Probe. CC13xx/CC26xx parts are looked up by their wafer ID, and
+// others by their chip ID. A device whose flash length is not
+// info.FlashSize does not match, unless the flash size is not known.
+// Ties are broken as by LookupChipID.
+func (db *DB) Identify(info *ccboot.DeviceInfo) (*Device, error) {
+	var best *Device
+	for _, d := range db.Devices {
+		if info.WaferID != 0 && uint32(d.WaferID) != info.WaferID {
+			continue
+		}
+		if info.WaferID == 0 && !d.Matches(info.ChipID) {
+			continue
+		}
+		if info.FlashSize != 0 && uint32(d.FlashLength) != info.FlashSize {
+			continue
+		}
+		if best == nil || better(d, best) {
+			best = d
+		}
+	}
+	if best == nil {
+		if info.WaferID != 0 {
+			return nil, fmt.Errorf("%w: wafer ID 0x%.4X with %d KB of flash", ErrUnknownChip, info.WaferID, info.FlashSize/1024)
+		}
+		return nil, fmt.Errorf("%w: 0x%.8X with %d KB of flash", ErrUnknownChip, info.ChipID, info.FlashSize/1024)
+	}
+	return best, nil
+}
+
+// LookupChipID returns the device identified by the value returned from
+// ccboot's Device.GetChipID. When several devices match, the one whose
+// mask checks the most bits wins, and then the first by name.
+//
+// CC13xx/CC26xx parts that share a die and radio protocols also share a
+// chip ID; Identify tells them apart.
+func (db *DB) LookupChipID(chipID uint32) (*Device, error) {
+	var best *Device
+	for _, d := range db.Devices {
+		if !d.Matches(chipID) {
+			continue
+		}
+		if best == nil || better(d, best) {
+			best = d
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%w: 0x%.8X", ErrUnknownChip, chipID)
+	}
+	return best, nil
+}
+
+// LookupWaferID returns the device whose die has the wafer ID read from
+// ICEPICK_DEVICE_ID. Parts that share a die may differ in flash size,
+// which only Identify checks, and the first by name is returned.
+func (db *DB) LookupWaferID(waferID uint32) (*Device, error) {
+	var best *Device
+	for _, d := range db.Devices {
+		if d.WaferID == 0 || uint32(d.WaferID) != waferID {
+			continue
+		}
+		if best == nil || d.Name < best.Name {
+			best = d
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("%w: wafer ID 0x%.4X", ErrUnknownChip, waferID)
+	}
+	return best, nil
+}
+
+// better reports whether d is a closer chip ID match than best: its
+// mask checks more bits, or as many and it is first by name
+func better(d, best *Device) bool {
+	if maskBits(d) != maskBits(best) {
+		return maskBits(d) > maskBits(best)
+	}
+	return d.Name < best.Name
+}
+
+// maskBits counts the chip ID bits that d checks
+func maskBits(d *Device) int {
+	mask := uint32(d.ChipIDMask)
+	if mask == 0 {
+		return 32
+	}
+	n := 0
+	for ; mask != 0; mask &= mask - 1 {
+		n++
+	}
+	return n
+}
+
+// stripComments blanks out // comments that are not inside strings
+func stripComments(data []byte) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+	inString := false
+	for i := 0; i < len(out); i++ {
+		switch {
+		case inString && out[i] == '\\':
+			i++
+		case out[i] == '"':
+			inString = !inString
+		case !inString && out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		}
+	}
+	return out
+}
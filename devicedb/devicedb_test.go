@@ -0,0 +1,221 @@
+package devicedb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+)
+
+func TestLoadConfig(t *testing.T) {
+	db, err := Load("../config.json")
+	if err != nil {
+		t.Fatalf("Error loading config.json: %v", err)
+	}
+	d, err := db.LookupChipID(0x8002F000)
+	if err != nil {
+		t.Fatalf("Error looking up chip ID: %v", err)
+	}
+	if d.Name != "CC2650F128" || d.FlashStart != 0 || d.FlashLength != 0x20000 {
+		t.Errorf("Device is %+v", d)
+	}
+	if s := d.Sections[".ccfg"]; s.Addr != 0x1FFA8 || s.Len != 0x58 {
+		t.Errorf(".ccfg section is %+v", s)
+	}
+	if v := d.Variables["BL_BACKDOOR_CONFIG"]; v.Addr != 0x1FFD8 || v.BitSize != 32 {
+		t.Errorf("BL_BACKDOOR_CONFIG is %+v", v)
+	}
+
+	if _, err := db.LookupChipID(0x12345678); !errors.Is(err, ErrUnknownChip) {
+		t.Errorf("Looking up an unknown chip returned %v, expected %v", err, ErrUnknownChip)
+	}
+}
+
+func TestParse(t *testing.T) {
+	db, err := Parse([]byte(`{
+		// a comment
+		"devices": {
+			"A": {"chipid": "0xB000", "chipidmask": "0xF000", "flashstart": 4096, "flashlength": "2000"},
+			"B": {"chipid": "0xB9A0", "flashlength": "0x1000", "sections": {"// not a comment": {"addr": "0x0", "len": "0x4"}}}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Error parsing database: %v", err)
+	}
+	a, _ := db.Lookup("A")
+	if a == nil || a.FlashStart != 0x1000 || a.FlashLength != 0x2000 {
+		t.Errorf("Device A is %+v", a)
+	}
+	b, _ := db.Lookup("B")
+	if _, ok := b.Sections["// not a comment"]; !ok {
+		t.Errorf("Comment stripping changed a string: %+v", b.Sections)
+	}
+
+	// the exact match wins over the masked one
+	if d, err := db.LookupChipID(0xB9A0); err != nil || d.Name != "B" {
+		t.Errorf("Looking up 0xB9A0 returned %v, %v", d, err)
+	}
+	if d, err := db.LookupChipID(0xB123); err != nil || d.Name != "A" {
+		t.Errorf("Looking up 0xB123 returned %v, %v", d, err)
+	}
+
+	// leading zeros do not make a value octal
+	for str, want := range map[string]Hex{
+		`"00020000"`:   0x20000,
+		`"08000000"`:   0x8000000,
+		`"0x0001FFA8"`: 0x1FFA8,
+		`"0X10"`:       0x10,
+	} {
+		var h Hex
+		if err := h.UnmarshalJSON([]byte(str)); err != nil || h != want {
+			t.Errorf("Parsing %s returned %v, %v, expected %v", str, h, err, want)
+		}
+	}
+
+	for _, bad := range []string{
+		`{"devices": {"A": {"chipid": "0xZZ"}}}`,
+		`{"devices": {"A": {"chipid": "0x100000000"}}}`,
+		`{"devices": {"A": {"chipid": true}}}`,
+		`{"devices": {"A": {"chipid": "0x"}}}`,
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Parsed %s", bad)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	db := Default()
+	if _, err := db.LookupChipID(0x8002F000); err != nil {
+		t.Fatalf("Built-in database lacks the CC2650F128: %v", err)
+	}
+	user, err := Parse([]byte(`{"devices": {
+		"CC2650F128": {"chipid": "0x8002F000", "flashlength": "0x10000"},
+		"Custom": {"chipid": "0x1234"}
+	}}`))
+	if err != nil {
+		t.Fatalf("Error parsing database: %v", err)
+	}
+	db.Merge(user)
+	if d, _ := db.Lookup("CC2650F128"); d.FlashLength != 0x10000 {
+		t.Errorf("Merged CC2650F128 has flash length %v", d.FlashLength)
+	}
+	if _, err := db.LookupChipID(0x1234); err != nil {
+		t.Errorf("Merged device not found: %v", err)
+	}
+	// merging does not change the built-in set
+	if d, _ := Default().Lookup("CC2650F128"); d.FlashLength != 0x20000 {
+		t.Errorf("Built-in CC2650F128 has flash length %v", d.FlashLength)
+	}
+}
+
+func TestBuiltinProfiles(t *testing.T) {
+	db := Default()
+	tests := []struct {
+		family     string
+		flashBase  uint32
+		flashSize  uint32
+		sectorSize uint32
+		ccfg       bool
+	}{
+		{"CC2538", 0x200000, 0x80000, 0x800, false},
+		{"CC26x0", 0x0, 0x20000, 0x1000, true},
+		{"CC13x0", 0x0, 0x20000, 0x1000, true},
+		{"CC26x2", 0x0, 0x58000, 0x2000, true},
+		{"CC13x2", 0x0, 0x58000, 0x2000, true},
+	}
+	for _, tt := range tests {
+		var d *Device
+		for _, dev := range db.Devices {
+			if dev.Family == tt.family {
+				d = dev
+			}
+		}
+		if d == nil {
+			t.Errorf("No built-in profile for the %s", tt.family)
+			continue
+		}
+		g := d.Geometry()
+		if g.FlashBase != tt.flashBase || g.FlashSize != tt.flashSize || g.SectorSize != tt.sectorSize {
+			t.Errorf("%s geometry is %+v", d.Name, g)
+		}
+		if g.FlashSize%g.SectorSize != 0 || d.RAMLength == 0 {
+			t.Errorf("%s has a bad layout: %+v", d.Name, d)
+		}
+		ccfg, ok := d.CCFG()
+		if ok != tt.ccfg {
+			t.Errorf("%s CCFG presence is %v, expected %v", d.Name, ok, tt.ccfg)
+		}
+		if ok && (ccfg.Len != 0x58 || uint32(ccfg.Addr)+uint32(ccfg.Len) != g.FlashBase+g.FlashSize) {
+			t.Errorf("%s CCFG is %+v, expected the last 0x58 bytes of flash", d.Name, ccfg)
+		}
+		if d.Supports(ccboot.COMMAND_SET_CCFG) != tt.ccfg {
+			t.Errorf("%s support for %v is wrong", d.Name, ccboot.COMMAND_SET_CCFG)
+		}
+		if v := d.Variant(); v.FlashBase != g.FlashBase || v.EraseSize != g.SectorSize || v.Supports(ccboot.COMMAND_SET_CCFG) != tt.ccfg ||
+			v.Supports(ccboot.COMMAND_DOWNLOAD_CRC) != d.Supports(ccboot.COMMAND_DOWNLOAD_CRC) {
+			t.Errorf("%s variant %v does not match its profile", d.Name, v)
+		}
+	}
+
+	if d, err := db.LookupChipID(0xB965); err != nil || d.Family != "CC2538" {
+		t.Errorf("Looking up chip ID 0xB965 returned %v, %v", d, err)
+	}
+	// the package and sequence fields of the USER_ID are ignored
+	for chipID, family := range map[uint32]string{
+		0x8002F000: "CC26x0",
+		0x8001F000: "CC26x0",
+		0x80828000: "CC13x0",
+	} {
+		if d, err := db.LookupChipID(chipID); err != nil || d.Family != family {
+			t.Errorf("Looking up chip ID 0x%.8X returned %v, %v, expected the %s", chipID, d, err, family)
+		}
+	}
+	if d, err := db.LookupChipID(0x2082F000); err != nil || d.Variant() != ccboot.VariantCC26x2 {
+		t.Errorf("Looking up chip ID 0x2082F000 returned %v, %v", d, err)
+	}
+	if d, err := db.LookupWaferID(0xB9BE); err != nil || d.Family != "CC13x0" {
+		t.Errorf("Looking up wafer ID 0xB9BE returned %v, %v", d, err)
+	}
+	for _, d := range db.Devices {
+		if d.ChipID == 0 && d.WaferID == 0 {
+			t.Errorf("%s has neither a chip ID nor a wafer ID", d.Name)
+		}
+		info := &ccboot.DeviceInfo{ChipID: uint32(d.ChipID), WaferID: uint32(d.WaferID)}
+		if found, err := db.Identify(info); err != nil || found.Variant() != d.Variant() || found.Geometry() != d.Geometry() {
+			t.Errorf("Identifying %s returned %v, %v", d.Name, found, err)
+		}
+	}
+	if _, err := db.LookupChipID(0); !errors.Is(err, ErrUnknownChip) {
+		t.Errorf("Looking up chip ID 0 returned %v", err)
+	}
+	// parts on one die are told apart by their flash size
+	small, err := Parse([]byte(`{"devices": {
+		"CC1310F64": {"family": "CC13x0", "waferid": "0xB9BE", "flashlength": "0x10000", "sectorsize": "0x1000"}
+	}}`))
+	if err != nil {
+		t.Fatalf("Error parsing database: %v", err)
+	}
+	db.Merge(small)
+	for size, name := range map[uint32]string{0x10000: "CC1310F64", 0x20000: "CC1310F128"} {
+		if d, err := db.Identify(&ccboot.DeviceInfo{WaferID: 0xB9BE, FlashSize: size}); err != nil || d.Name != name {
+			t.Errorf("Identifying wafer ID 0xB9BE with 0x%X bytes of flash returned %v, %v, expected %s", size, d, err, name)
+		}
+	}
+	if _, err := db.Identify(&ccboot.DeviceInfo{WaferID: 0xB9BE, FlashSize: 0x8000}); !errors.Is(err, ErrUnknownChip) {
+		t.Errorf("Identifying a flash size without a profile returned %v, expected %v", err, ErrUnknownChip)
+	}
+	if _, err := db.Identify(&ccboot.DeviceInfo{ChipID: 0xB964, FlashSize: 0x40000}); !errors.Is(err, ErrUnknownChip) {
+		t.Errorf("Identifying a 256 KB CC2538 returned %v, expected %v", err, ErrUnknownChip)
+	}
+
+	if _, err := Parse([]byte(`{"devices": {"A": {"commands": ["COMMAND_FOO"]}}}`)); err == nil {
+		t.Error("Parsed a device with an unknown command")
+	}
+	if _, err := Parse([]byte(`{"devices": {"A": {"family": "CC2538", "commands": ["COMMAND_BANK_ERASE"]}}}`)); err == nil {
+		t.Error("Parsed a device with a command its variant lacks")
+	}
+	if d, _ := db.Lookup("CC2538SF53"); d.Supports(ccboot.COMMAND_BANK_ERASE) || d.Supports(ccboot.COMMAND_MEMORY_WRITE) {
+		t.Errorf("%s supports %v or %v", d.Name, ccboot.COMMAND_BANK_ERASE, ccboot.COMMAND_MEMORY_WRITE)
+	}
+}
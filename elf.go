@@ -0,0 +1,74 @@
+package ccboot
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ELFSection reports where a named section of an ELF file is loaded
+type ELFSection struct {
+	Name string
+	// Address is the load (physical) address of the section, which is
+	// where it is flashed
+	Address uint32
+	Size    uint32
+	// VirtualAddress is where the program expects the section at run
+	// time, such as RAM for .data
+	VirtualAddress uint32
+}
+
+// ReadELF loads the PT_LOAD segments of an ELF file into an image at
+// their physical addresses (LMA), so that initialized data is placed
+// where the startup code copies it from. Only the bytes stored in the
+// file are loaded, so zero initialized memory such as .bss is skipped.
+//
+// The returned sections describe where each allocated section that has
+// file contents, such as .resetVecs, .text or .ccfg, ended up.
+func ReadELF(r io.ReaderAt) (*Image, []ELFSection, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	if f.Class != elf.ELFCLASS32 {
+		return nil, nil, fmt.Errorf("%w: ELF file is not 32 bit", ErrParse)
+	}
+
+	img := &Image{Entry: uint32(f.Entry), HasEntry: true}
+	var loads []*elf.Prog
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD || p.Filesz == 0 {
+			continue
+		}
+		data := make([]byte, p.Filesz)
+		if _, err := p.ReadAt(data, 0); err != nil {
+			return nil, nil, err
+		}
+		if err := img.Add(uint32(p.Paddr), data); err != nil {
+			return nil, nil, fmt.Errorf("segment at 0x%.8X: %w", p.Paddr, err)
+		}
+		loads = append(loads, p)
+	}
+
+	var sections []ELFSection
+	for _, s := range f.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 || s.Type == elf.SHT_NOBITS || s.Size == 0 {
+			continue
+		}
+		for _, p := range loads {
+			if s.Addr >= p.Vaddr && s.Addr+s.Size <= p.Vaddr+p.Filesz {
+				sections = append(sections, ELFSection{
+					Name:           s.Name,
+					Address:        uint32(p.Paddr + (s.Addr - p.Vaddr)),
+					Size:           uint32(s.Size),
+					VirtualAddress: uint32(s.Addr),
+				})
+				break
+			}
+		}
+	}
+	sort.Slice(sections, func(i, j int) bool { return sections[i].Address < sections[j].Address })
+	return img, sections, nil
+}
@@ -0,0 +1,155 @@
+package ccboot_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+)
+
+// testSection is a section of the ELF file built by buildELF
+type testSection struct {
+	name  string
+	typ   elf.SectionType
+	flags elf.SectionFlag
+	addr  uint32
+	data  []byte
+	size  uint32 // for NOBITS
+}
+
+// buildELF creates a little endian ARM ELF32 executable with the given
+// sections and program headers. Each program header's Off field is the
+// index of the section that starts the segment.
+func buildELF(sections []testSection, progs []elf.Prog32) []byte {
+	const (
+		ehsize    = 52
+		phentsize = 32
+		shentsize = 40
+	)
+	shstrtab := []byte{0}
+	names := make([]uint32, len(sections))
+	for i, s := range sections {
+		names[i] = uint32(len(shstrtab))
+		shstrtab = append(shstrtab, s.name...)
+		shstrtab = append(shstrtab, 0)
+	}
+	shstrndx := uint32(len(shstrtab))
+	shstrtab = append(shstrtab, ".shstrtab\x00"...)
+
+	// section contents follow the program headers
+	offsets := make([]uint32, len(sections))
+	var body bytes.Buffer
+	off := uint32(ehsize + phentsize*len(progs))
+	for i, s := range sections {
+		offsets[i] = off + uint32(body.Len())
+		body.Write(s.data)
+	}
+	strOff := off + uint32(body.Len())
+	body.Write(shstrtab)
+	shoff := off + uint32(body.Len())
+
+	var buf bytes.Buffer
+	hdr := elf.Header32{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_ARM),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     0x4D,
+		Phoff:     ehsize,
+		Shoff:     shoff,
+		Ehsize:    ehsize,
+		Phentsize: phentsize,
+		Phnum:     uint16(len(progs)),
+		Shentsize: shentsize,
+		Shnum:     uint16(len(sections) + 2),
+		Shstrndx:  uint16(len(sections) + 1),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(&buf, binary.LittleEndian, hdr)
+	for _, p := range progs {
+		p.Off = offsets[p.Off]
+		binary.Write(&buf, binary.LittleEndian, p)
+	}
+	buf.Write(body.Bytes())
+
+	binary.Write(&buf, binary.LittleEndian, elf.Section32{})
+	for i, s := range sections {
+		size := uint32(len(s.data))
+		if s.typ == elf.SHT_NOBITS {
+			size = s.size
+		}
+		binary.Write(&buf, binary.LittleEndian, elf.Section32{
+			Name:      names[i],
+			Type:      uint32(s.typ),
+			Flags:     uint32(s.flags),
+			Addr:      s.addr,
+			Off:       offsets[i],
+			Size:      size,
+			Addralign: 4,
+		})
+	}
+	binary.Write(&buf, binary.LittleEndian, elf.Section32{
+		Name: shstrndx,
+		Type: uint32(elf.SHT_STRTAB),
+		Off:  strOff,
+		Size: uint32(len(shstrtab)),
+	})
+	return buf.Bytes()
+}
+
+func TestReadELF(t *testing.T) {
+	alloc := elf.SHF_ALLOC
+	vecs := testImage(0x3C)
+	text := testImage(0x20)
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	ccfg := bytes.Repeat([]byte{0xFF}, 0x58)
+	file := buildELF([]testSection{
+		{".resetVecs", elf.SHT_PROGBITS, alloc | elf.SHF_EXECINSTR, 0x0, vecs, 0},
+		{".text", elf.SHT_PROGBITS, alloc | elf.SHF_EXECINSTR, 0x3C, text, 0},
+		{".data", elf.SHT_PROGBITS, alloc | elf.SHF_WRITE, 0x20000000, data, 0},
+		{".bss", elf.SHT_NOBITS, alloc | elf.SHF_WRITE, 0x20000008, nil, 0x100},
+		{".ccfg", elf.SHT_PROGBITS, alloc, 0x1FFA8, ccfg, 0},
+	}, []elf.Prog32{
+		{Type: uint32(elf.PT_LOAD), Off: 0, Vaddr: 0x0, Paddr: 0x0, Filesz: 0x5C, Memsz: 0x5C},
+		// .data is stored after .text and copied to RAM at startup
+		{Type: uint32(elf.PT_LOAD), Off: 2, Vaddr: 0x20000000, Paddr: 0x5C, Filesz: 8, Memsz: 0x108},
+		{Type: uint32(elf.PT_LOAD), Off: 4, Vaddr: 0x1FFA8, Paddr: 0x1FFA8, Filesz: 0x58, Memsz: 0x58},
+	})
+
+	img, sections, err := ccboot.ReadELF(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("Error reading ELF: %v", err)
+	}
+	if len(img.Segments) != 2 {
+		t.Fatalf("Image has %d segments, expected 2", len(img.Segments))
+	}
+	flash := append(append(append([]byte(nil), vecs...), text...), data...)
+	if seg := img.Segments[0]; seg.Address != 0x0 || !bytes.Equal(seg.Data, flash) {
+		t.Errorf("First segment is at 0x%X with %d bytes", seg.Address, len(seg.Data))
+	}
+	if seg := img.Segments[1]; seg.Address != 0x1FFA8 || !bytes.Equal(seg.Data, ccfg) {
+		t.Errorf("Second segment is at 0x%X with %d bytes", seg.Address, len(seg.Data))
+	}
+	if !img.HasEntry || img.Entry != 0x4D {
+		t.Errorf("Entry is 0x%X, expected 0x4D", img.Entry)
+	}
+
+	expected := []ccboot.ELFSection{
+		{Name: ".resetVecs", Address: 0x0, Size: 0x3C, VirtualAddress: 0x0},
+		{Name: ".text", Address: 0x3C, Size: 0x20, VirtualAddress: 0x3C},
+		{Name: ".data", Address: 0x5C, Size: 8, VirtualAddress: 0x20000000},
+		{Name: ".ccfg", Address: 0x1FFA8, Size: 0x58, VirtualAddress: 0x1FFA8},
+	}
+	if len(sections) != len(expected) {
+		t.Fatalf("Sections are %+v, expected %+v", sections, expected)
+	}
+	for i := range expected {
+		if sections[i] != expected[i] {
+			t.Errorf("Section %d is %+v, expected %+v", i, sections[i], expected[i])
+		}
+	}
+}
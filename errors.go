@@ -0,0 +1,113 @@
+package ccboot
+
+import (
+	"fmt"
+)
+
+// Reason classifies why a command failed
+type Reason byte
+
+const (
+	// ReasonNack means the device NACKed every attempt to send the packet
+	ReasonNack = Reason(0)
+	// ReasonGarbage means the device answered with a byte that was
+	// neither an ACK nor a NACK
+	ReasonGarbage = Reason(1)
+	// ReasonTimeout means the device did not answer in time
+	ReasonTimeout = Reason(2)
+	// ReasonBadChecksum means every response packet was malformed
+	ReasonBadChecksum = Reason(3)
+	// ReasonBadResponse means the response had an unexpected length
+	ReasonBadResponse = Reason(4)
+	// ReasonStatus means GetStatus reported a failure
+	ReasonStatus = Reason(5)
+)
+
+var reason2String = map[Reason]string{
+	ReasonNack:        "NACK",
+	ReasonGarbage:     "garbage",
+	ReasonTimeout:     "timeout",
+	ReasonBadChecksum: "bad checksum",
+	ReasonBadResponse: "bad response",
+	ReasonStatus:      "status",
+}
+
+func (r Reason) String() string {
+	if str, ok := reason2String[r]; ok {
+		return str
+	}
+	return fmt.Sprintf("0x%X", byte(r))
+}
+
+// CommandError describes a command that the device did not complete.
+//
+// It unwraps to the sentinel error that used to describe the failure,
+// so errors.Is(err, ErrDevice) keeps working. A timeout also matches
+// ErrDeviceTimeout and a bad checksum also matches ErrBadPacket.
+type CommandError struct {
+	Command CommandType
+	// Attempts is the number of times the exchange was tried
+	Attempts int
+	Reason   Reason
+	// Status is the result of GetStatus when Reason is ReasonStatus
+	Status Status
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	if e.Reason == ReasonStatus {
+		return fmt.Sprintf("%v failed with status %v", e.Command, e.Status)
+	}
+	return fmt.Sprintf("%v failed after %d attempts: %v", e.Command, e.Attempts, e.Reason)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CommandError) Is(target error) bool {
+	switch target {
+	case ErrDeviceTimeout:
+		return e.Reason == ReasonTimeout
+	case ErrBadPacket:
+		return e.Reason == ReasonBadChecksum
+	}
+	return false
+}
+
+// commandError wraps a timeout of the last sent command in a
+// CommandError and passes any other error through
+func (d *Device) commandError(err error, attempts int) error {
+	if err == ErrDeviceTimeout {
+		return &CommandError{Command: d.state.lastCmd, Attempts: attempts, Reason: ReasonTimeout, Err: err}
+	}
+	return err
+}
+
+// badResponse reports a response of the wrong length to the last command
+func (d *Device) badResponse() error {
+	return &CommandError{Command: d.state.lastCmd, Attempts: 1, Reason: ReasonBadResponse, Err: ErrDevice}
+}
+
+// checkStatus follows cmd with GetStatus when the device checks status,
+// and turns a failed status into a CommandError
+func (d *Device) checkStatus(cmd CommandType) error {
+	if !d.opts.CheckStatus {
+		return nil
+	}
+	status, err := d.GetStatus()
+	if err != nil {
+		return err
+	}
+	if status != COMMAND_RET_SUCCESS {
+		return &CommandError{Command: cmd, Attempts: 1, Reason: ReasonStatus, Status: status, Err: ErrDevice}
+	}
+	return nil
+}
+
+// checked returns a copy of d that checks the status of every command
+func (d *Device) checked() *Device {
+	d2 := *d
+	d2.opts.CheckStatus = true
+	return &d2
+}
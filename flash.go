@@ -0,0 +1,410 @@
+package ccboot
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrVerify = errors.New("Flash contents do not match the expected data")
+
+// FlashError reports where a flash operation failed
+type FlashError struct {
+	// Address is the flash address of the first byte that failed
+	Address uint32
+	// Offset is the index into the caller's data of that byte, when
+	// there is data
+	Offset int
+	Err    error
+}
+
+func (e *FlashError) Error() string {
+	return fmt.Sprintf("flash failed at 0x%.8X (offset %d): %v", e.Address, e.Offset, e.Err)
+}
+
+func (e *FlashError) Unwrap() error {
+	return e.Err
+}
+
+// VerifyError reports a range of flash that differs from the expected
+// data. It matches ErrVerify.
+type VerifyError struct {
+	// Address and Size give the range that holds all of the mismatches
+	// found in the first region that failed
+	Address uint32
+	Size    uint32
+	// Offset is the index into the caller's data of Address
+	Offset int
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%v in 0x%.8X-0x%.8X (offset %d)", ErrVerify, e.Address, e.Address+e.Size-1, e.Offset)
+}
+
+func (e *VerifyError) Unwrap() error {
+	return ErrVerify
+}
+
+// WriteFlash programs data into flash starting at address, which must
+// already be erased.
+//
+// The data is split into Download commands of at most DownloadMaxSize
+// bytes and SendData commands of at most SendDataMaxSize bytes, and the
+// status of every command is checked. An address or length that is not
+// word aligned is padded with 0xFF, which leaves the neighboring flash
+// unchanged. On failure the returned *FlashError gives the offset into
+// data of the first chunk that was not programmed.
+//
+// When the device supports COMMAND_DOWNLOAD_CRC, which needs its
+// variant to be detected first unless Options.Variant is set, it is
+// used in place of Download so that the device also checks each block it programmed. The
+// padding then holds the neighboring flash, read back from the device,
+// so that the CRC matches what the block holds once programmed.
+//
+// Data that holds a CCFG that would lock the device out is refused with
+// a *LockoutError, unless Options.AllowLockout is set.
+func (d *Device) WriteFlash(address uint32, data []byte) error {
+	if err := d.checkLockout(Segment{Address: address, Data: data}); err != nil {
+		return err
+	}
+	return d.writeFlash(address, data, d.startProgress(len(data)))
+}
+
+// writeFlash implements WriteFlash, reporting to prog
+func (d *Device) writeFlash(address uint32, data []byte, prog *progress) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if uint64(address)+uint64(len(data)) > 1<<32 {
+		return ErrBadArguments
+	}
+
+	// pad the data out to whole flash words
+	head := address % FlashWordSize
+	buf := make([]byte, 0, int(head)+len(data)+FlashWordSize)
+	for i := uint32(0); i < head; i++ {
+		buf = append(buf, 0xFF)
+	}
+	buf = append(buf, data...)
+	for len(buf)%FlashWordSize != 0 {
+		buf = append(buf, 0xFF)
+	}
+	start := address - head
+
+	v, err := d.variant()
+	if err != nil {
+		return err
+	}
+	dc := d.checked()
+	useCRC := v.Supports(COMMAND_DOWNLOAD_CRC)
+	if useCRC {
+		// the device checks the CRC against flash, so the padding must
+		// hold what the partly covered words already contain
+		n := len(buf)
+		tail := n - int(head) - len(data)
+		if head > 0 {
+			if err := dc.fillPadding(start, buf[:FlashWordSize], 0, int(head)); err != nil {
+				return flashError(start, head, 0, err)
+			}
+		}
+		if tail > 0 {
+			last := n - FlashWordSize
+			if err := dc.fillPadding(start+uint32(last), buf[last:], FlashWordSize-tail, FlashWordSize); err != nil {
+				return flashError(start, head, last, err)
+			}
+		}
+	}
+	for off := 0; off < len(buf); off += DownloadMaxSize {
+		end := off + DownloadMaxSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		var err error
+		if useCRC {
+			err = dc.DownloadCRC(start+uint32(off), uint32(end-off), ChecksumCRC32(buf[off:end], 0))
+		} else {
+			err = dc.Download(start+uint32(off), uint32(end-off))
+		}
+		if err != nil {
+			return flashError(start, head, off, err)
+		}
+		for chunk := off; chunk < end; chunk += SendDataMaxSize {
+			chunkEnd := chunk + SendDataMaxSize
+			if chunkEnd > end {
+				chunkEnd = end
+			}
+			if err := dc.SendData(buf[chunk:chunkEnd]); err != nil {
+				return flashError(start, head, chunk, err)
+			}
+			prog.report(PhaseProgram, start+uint32(chunk), chunkEnd-chunk)
+		}
+	}
+	return nil
+}
+
+// fillPadding replaces bytes from to to of word, the flash word at
+// address, with what the device holds there
+func (d *Device) fillPadding(address uint32, word []byte, from, to int) error {
+	data, err := d.MemoryRead(address, ReadWriteType32Bit, 1)
+	if err != nil {
+		return err
+	}
+	if len(data) != FlashWordSize {
+		return d.badResponse()
+	}
+	copy(word[from:to], data[from:to])
+	return nil
+}
+
+// flashError reports a failure at offset off of the padded buffer that
+// starts at start and has head bytes of padding
+func flashError(start, head uint32, off int, err error) error {
+	offset := off - int(head)
+	if offset < 0 {
+		offset = 0
+	}
+	return &FlashError{Address: start + head + uint32(offset), Offset: offset, Err: err}
+}
+
+// VerifyFlash checks that flash starting at address holds data, by
+// comparing the device's CRC32 with ChecksumCRC32 for each region of
+// VerifyBlockSize bytes. When a region differs, it is bisected to find
+// the first and last mismatching ranges of VerifyMinSize bytes, and a
+// *VerifyError spanning them is returned.
+func (d *Device) VerifyFlash(address uint32, data []byte) error {
+	return d.verifyFlash(address, data, d.startProgress(len(data)))
+}
+
+// verifyFlash implements VerifyFlash, reporting to prog
+func (d *Device) verifyFlash(address uint32, data []byte, prog *progress) error {
+	if uint64(address)+uint64(len(data)) > 1<<32 {
+		return ErrBadArguments
+	}
+	for off := 0; off < len(data); off += VerifyBlockSize {
+		end := off + VerifyBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		ok, err := d.crcMatches(address, data, off, end)
+		if err != nil {
+			return err
+		}
+		if ok {
+			prog.report(PhaseVerify, address+uint32(off), end-off)
+			continue
+		}
+
+		first, err := d.bisectMismatch(address, data, off, end, true)
+		if err != nil {
+			return err
+		}
+		last, err := d.bisectMismatch(address, data, off, end, false)
+		if err != nil {
+			return err
+		}
+		lastEnd := last + VerifyMinSize
+		if lastEnd > end {
+			lastEnd = end
+		}
+		return &VerifyError{
+			Address: address + uint32(first),
+			Size:    uint32(lastEnd - first),
+			Offset:  first,
+		}
+	}
+	return nil
+}
+
+// crcMatches compares the device's CRC32 of data[start:end] with the
+// expected one
+func (d *Device) crcMatches(address uint32, data []byte, start, end int) (bool, error) {
+	crc, err := d.CRC32(address+uint32(start), uint32(end-start), 0)
+	if err != nil {
+		return false, err
+	}
+	return crc == ChecksumCRC32(data[start:end], 0), nil
+}
+
+// bisectMismatch narrows the mismatching range data[start:end] down to
+// VerifyMinSize bytes, keeping to the first mismatch if first is set and
+// to the last one otherwise. It returns the offset of the narrowed range.
+func (d *Device) bisectMismatch(address uint32, data []byte, start, end int, first bool) (int, error) {
+	for end-start > VerifyMinSize {
+		mid := start + (end-start)/2
+		mid -= mid % VerifyMinSize
+		if mid == start {
+			mid += VerifyMinSize
+		}
+		// check the half that we would like the mismatch to be in
+		lo, hi := start, mid
+		if !first {
+			lo, hi = mid, end
+		}
+		ok, err := d.crcMatches(address, data, lo, hi)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			// the mismatch is in the other half
+			lo, hi = mid, end
+			if !first {
+				lo, hi = start, mid
+			}
+		}
+		start, end = lo, hi
+	}
+	return start, nil
+}
+
+// ReadMemory reads length bytes starting at address with as many
+// MemoryRead commands as needed. The word aligned part of the region is
+// read with 32 bit accesses, so that peripheral registers can be dumped
+// too, and any unaligned head and tail is read a byte at a time.
+func (d *Device) ReadMemory(address, length uint32) ([]byte, error) {
+	if uint64(address)+uint64(length) > 1<<32 {
+		return nil, ErrBadArguments
+	}
+	buf := make([]byte, 0, length)
+	end := address + length
+	prog := d.startProgress(int(length))
+
+	// unaligned head
+	head := (FlashWordSize - address%FlashWordSize) % FlashWordSize
+	if head > length {
+		head = length
+	}
+	if head > 0 {
+		data, err := d.readMemory(address, ReadWriteType8Bit, uint8(head), head)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, data...)
+		prog.report(PhaseRead, address, len(data))
+		address += head
+	}
+
+	// whole words
+	for end-address >= FlashWordSize {
+		words := (end - address) / FlashWordSize
+		if words > uint32(ReadMaxCount32Bit) {
+			words = uint32(ReadMaxCount32Bit)
+		}
+		data, err := d.readMemory(address, ReadWriteType32Bit, uint8(words), words*FlashWordSize)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, data...)
+		prog.report(PhaseRead, address, len(data))
+		address += words * FlashWordSize
+	}
+
+	// unaligned tail
+	if address < end {
+		data, err := d.readMemory(address, ReadWriteType8Bit, uint8(end-address), end-address)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, data...)
+		prog.report(PhaseRead, address, len(data))
+	}
+	return buf, nil
+}
+
+// readMemory issues a single MemoryRead and checks the response size
+func (d *Device) readMemory(address uint32, typ ReadWriteType, count uint8, size uint32) ([]byte, error) {
+	data, err := d.MemoryRead(address, typ, count)
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(data)) != size {
+		return nil, d.badResponse()
+	}
+	return data, nil
+}
+
+// UpdateResult summarizes an UpdateFlash
+type UpdateResult struct {
+	// Skipped is the number of sectors that already held the image
+	Skipped int
+	// Rewritten lists the addresses of the sectors that were erased and
+	// programmed
+	Rewritten []uint32
+}
+
+// UpdateFlash programs data at address, rewriting only the flash sectors
+// of sectorSize bytes whose CRC32 differs from the image.
+//
+// A sector that the image only partly covers keeps the rest of its
+// contents, which are read back before the sector is erased. The
+// sectorSize must be the device's, as given by SectorSize.
+func (d *Device) UpdateFlash(address uint32, data []byte, sectorSize uint32) (UpdateResult, error) {
+	var result UpdateResult
+	if sectorSize == 0 || sectorSize%FlashWordSize != 0 {
+		return result, ErrBadArguments
+	}
+	if uint64(address)+uint64(len(data)) > 1<<32 {
+		return result, ErrBadArguments
+	}
+	if err := d.checkSectorSize(sectorSize); err != nil {
+		return result, err
+	}
+	if err := d.checkLockout(Segment{Address: address, Data: data}); err != nil {
+		return result, err
+	}
+
+	prog := d.startProgress(len(data))
+	dc := d.checked()
+	// the inner reads and writes are reported per sector
+	dc.opts.OnProgress = nil
+	// the image was checked above, and the merged sector contents only
+	// add back what the device already holds
+	dc.opts.AllowLockout = true
+	end := uint64(address) + uint64(len(data))
+	for sector := uint64(address - address%sectorSize); sector < end; sector += uint64(sectorSize) {
+		// the part of the sector covered by the image
+		start := sector
+		if start < uint64(address) {
+			start = uint64(address)
+		}
+		stop := sector + uint64(sectorSize)
+		if stop > end {
+			stop = end
+		}
+		image := data[start-uint64(address) : stop-uint64(address)]
+
+		crc, err := dc.CRC32(uint32(start), uint32(len(image)), 0)
+		if err != nil {
+			return result, err
+		}
+		if crc == ChecksumCRC32(image, 0) {
+			result.Skipped++
+			prog.report(PhaseVerify, uint32(start), len(image))
+			continue
+		}
+
+		// merge the image into the current sector contents
+		contents, writeAddr := image, start
+		if start != sector || stop != sector+uint64(sectorSize) {
+			contents, err = dc.ReadMemory(uint32(sector), sectorSize)
+			if err != nil {
+				return result, err
+			}
+			copy(contents[start-sector:], image)
+			writeAddr = sector
+		}
+
+		if err := dc.SectorErase(uint32(sector)); err != nil {
+			return result, flashError(address, 0, int(start-uint64(address)), err)
+		}
+		prog.report(PhaseErase, uint32(sector), 0)
+		if err := dc.WriteFlash(uint32(writeAddr), contents); err != nil {
+			var flashErr *FlashError
+			if errors.As(err, &flashErr) {
+				return result, flashError(address, 0, int(int64(flashErr.Address)-int64(address)), flashErr.Err)
+			}
+			return result, err
+		}
+		result.Rewritten = append(result.Rewritten, uint32(sector))
+		prog.report(PhaseProgram, uint32(start), len(image))
+	}
+	return result, nil
+}
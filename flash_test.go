@@ -0,0 +1,259 @@
+package ccboot_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+	"github.com/openchirp/ccboot/ccboottest"
+)
+
+// testImage returns size bytes of data that is not all 0xFF
+func testImage(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestWriteFlash(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	data := testImage(3*ccboot.DownloadMaxSize + 5)
+
+	if err := d.WriteFlash(0x1003, data); err != nil {
+		t.Fatalf("Error writing flash: %v", err)
+	}
+	flash := b.Flash()
+	if !bytes.Equal(flash[0x1003:0x1003+len(data)], data) {
+		t.Error("Flash contents do not match the written data")
+	}
+	// the padding must leave the neighboring bytes erased
+	for _, addr := range []int{0x1000, 0x1002, 0x1003 + len(data)} {
+		if flash[addr] != 0xFF {
+			t.Errorf("Flash byte at 0x%X is 0x%.2X, expected 0xFF", addr, flash[addr])
+		}
+	}
+}
+
+func TestWriteFlashFailureOffset(t *testing.T) {
+	d, _ := newSimDevice(t, ccboottest.DefaultConfig)
+	// protect sector 3
+	if err := d.SetCCFG(ccboot.ID_SECTOR_PROT, 0xFFFFFFF7); err != nil {
+		t.Fatalf("Error setting CCFG: %v", err)
+	}
+
+	err := d.WriteFlash(0x1000, testImage(0x4000))
+	var flashErr *ccboot.FlashError
+	if !errors.As(err, &flashErr) {
+		t.Fatalf("WriteFlash returned %v, expected a *FlashError", err)
+	}
+	if flashErr.Address != 0x3000 || flashErr.Offset != 0x2000 {
+		t.Errorf("WriteFlash failed at 0x%X (offset 0x%X), expected 0x3000 (offset 0x2000)", flashErr.Address, flashErr.Offset)
+	}
+	var cmdErr *ccboot.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Status != ccboot.COMMAND_RET_FLASH_FAIL {
+		t.Errorf("WriteFlash returned %v, expected status %v", err, ccboot.COMMAND_RET_FLASH_FAIL)
+	}
+}
+
+func TestChecksumCRC32(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	data := testImage(100)
+	b.LoadFlash(0x800, data)
+
+	for _, rcount := range []uint32{0, 1, 3} {
+		crc, err := d.CRC32(0x800, uint32(len(data)), rcount)
+		if err != nil {
+			t.Fatalf("Error computing CRC32: %v", err)
+		}
+		if expected := ccboot.ChecksumCRC32(data, rcount); crc != expected {
+			t.Errorf("CRC32 with read count %d is 0x%.8X, expected 0x%.8X", rcount, crc, expected)
+		}
+	}
+}
+
+func TestChecksumCRC32Vectors(t *testing.T) {
+	// 0xCBF43926 is the check value of the IEEE 802.3 CRC32 that the ROM
+	// documents. The repeated read values are that CRC over each byte
+	// repeated rcount+1 times, computed with Python's zlib.crc32 rather
+	// than taken from a hardware capture.
+	for _, tt := range []struct {
+		rcount uint32
+		crc    uint32
+	}{
+		{0, 0xCBF43926},
+		{1, 0x938C6CCF},
+		{3, 0xB744DA74},
+	} {
+		if crc := ccboot.ChecksumCRC32([]byte("123456789"), tt.rcount); crc != tt.crc {
+			t.Errorf("CRC32 with read count %d is 0x%.8X, expected 0x%.8X", tt.rcount, crc, tt.crc)
+		}
+	}
+}
+
+func TestVerifyFlash(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	data := testImage(3*ccboot.VerifyBlockSize + 10)
+	b.LoadFlash(0x2000, data)
+
+	if err := d.VerifyFlash(0x2000, data); err != nil {
+		t.Fatalf("Error verifying flash: %v", err)
+	}
+
+	// corrupt two bytes in the second region
+	b.LoadFlash(0x2000+0x1101, []byte{^data[0x1101]})
+	b.LoadFlash(0x2000+0x1802, []byte{^data[0x1802]})
+	err := d.VerifyFlash(0x2000, data)
+	var verifyErr *ccboot.VerifyError
+	if !errors.As(err, &verifyErr) {
+		t.Fatalf("VerifyFlash returned %v, expected a *VerifyError", err)
+	}
+	if !errors.Is(err, ccboot.ErrVerify) {
+		t.Errorf("VerifyFlash returned %v, expected it to match %v", err, ccboot.ErrVerify)
+	}
+	if verifyErr.Offset != 0x1100 || verifyErr.Address != 0x3100 || verifyErr.Size != 0x704 {
+		t.Errorf("VerifyFlash reported %+v, expected offset 0x1100 size 0x704", verifyErr)
+	}
+}
+
+func TestReadMemory(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	data := testImage(1000)
+	b.LoadFlash(0x4000, data)
+	b.SetMemory(0x20000000, data[:10])
+
+	tests := []struct {
+		address uint32
+		length  uint32
+		want    []byte
+	}{
+		{0x4000, 1000, data},
+		{0x4001, 998, data[1:999]},
+		{0x4003, 2, data[3:5]},
+		{0x4002, 0, []byte{}},
+		{0x20000001, 9, data[1:10]},
+	}
+	for _, tt := range tests {
+		got, err := d.ReadMemory(tt.address, tt.length)
+		if err != nil {
+			t.Fatalf("Error reading %d bytes at 0x%X: %v", tt.length, tt.address, err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("Reading %d bytes at 0x%X returned %X, expected %X", tt.length, tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateFlash(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	const sectorSize = 0x1000
+	old := testImage(4 * sectorSize)
+	b.LoadFlash(0x1080, old)
+	// data sharing the first sector with the image
+	b.LoadFlash(0x1000, []byte{0xA5, 0x5A})
+
+	image := append([]byte(nil), old...)
+	image[0x10] ^= 0xFF              // first sector, partly covered
+	image[2*sectorSize+0x10] ^= 0xFF // third sector
+	image[len(image)-1] ^= 0xFF      // last sector, partly covered
+
+	result, err := d.UpdateFlash(0x1080, image, sectorSize)
+	if err != nil {
+		t.Fatalf("Error updating flash: %v", err)
+	}
+	if result.Skipped != 2 {
+		t.Errorf("Skipped %d sectors, expected 2", result.Skipped)
+	}
+	expected := []uint32{0x1000, 0x3000, 0x5000}
+	if len(result.Rewritten) != len(expected) {
+		t.Fatalf("Rewrote sectors %X, expected %X", result.Rewritten, expected)
+	}
+	for i := range expected {
+		if result.Rewritten[i] != expected[i] {
+			t.Errorf("Rewrote sectors %X, expected %X", result.Rewritten, expected)
+		}
+	}
+
+	flash := b.Flash()
+	if !bytes.Equal(flash[0x1080:0x1080+len(image)], image) {
+		t.Error("Flash contents do not match the image")
+	}
+	if flash[0x1000] != 0xA5 || flash[0x1001] != 0x5A {
+		t.Errorf("Data outside of the image was lost: %X", flash[0x1000:0x1002])
+	}
+}
+
+func TestUpdateFlashSectorSize(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.CC2538Config)
+	old := testImage(0x1000)
+	b.LoadFlash(0x200000, old)
+	image := append([]byte(nil), old...)
+	image[0x900] ^= 0xFF
+
+	// the CC2538 erases 2 KB pages
+	if _, err := d.UpdateFlash(0x200000, image, 0x1000); !errors.Is(err, ccboot.ErrBadArguments) {
+		t.Fatalf("Update with 4 KB sectors returned %v, expected %v", err, ccboot.ErrBadArguments)
+	}
+	result, err := d.UpdateFlash(0x200000, image, 0x800)
+	if err != nil {
+		t.Fatalf("Error updating flash: %v", err)
+	}
+	if result.Skipped != 1 || len(result.Rewritten) != 1 || result.Rewritten[0] != 0x200800 {
+		t.Errorf("Update result is %+v, expected the second page rewritten", result)
+	}
+	if err := d.VerifyFlash(0x200000, image); err != nil {
+		t.Errorf("Error verifying flash: %v", err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var events []ccboot.Progress
+	d := ccboot.NewDeviceWithOptions(ccboottest.NewBootloader(ccboottest.DefaultConfig), ccboot.Options{
+		OnProgress: func(p ccboot.Progress) {
+			events = append(events, p)
+		},
+	})
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+	data := testImage(2*ccboot.DownloadMaxSize + 100)
+
+	if err := d.WriteFlash(0x1000, data); err != nil {
+		t.Fatalf("Error writing flash: %v", err)
+	}
+	if len(events) < len(data)/ccboot.SendDataMaxSize {
+		t.Fatalf("Got %d progress events, expected one per SendData", len(events))
+	}
+	for i, p := range events {
+		if p.Phase != ccboot.PhaseProgram || p.Total != uint32(len(data)) {
+			t.Errorf("Event %d is %+v", i, p)
+		}
+		if i > 0 && p.Done <= events[i-1].Done {
+			t.Errorf("Event %d did not advance: %+v", i, p)
+		}
+	}
+	if last := events[len(events)-1]; last.Done != last.Total || last.Rate <= 0 {
+		t.Errorf("Last event is %+v", last)
+	}
+
+	events = nil
+	data[0] ^= 0xFF
+	if _, err := d.UpdateFlash(0x1000, data, 0x1000); err != nil {
+		t.Fatalf("Error updating flash: %v", err)
+	}
+	phases := make([]ccboot.Phase, len(events))
+	for i, p := range events {
+		phases[i] = p.Phase
+	}
+	expected := []ccboot.Phase{ccboot.PhaseErase, ccboot.PhaseProgram, ccboot.PhaseVerify, ccboot.PhaseVerify}
+	if len(phases) != len(expected) {
+		t.Fatalf("UpdateFlash reported phases %v, expected %v", phases, expected)
+	}
+	for i := range expected {
+		if phases[i] != expected[i] {
+			t.Fatalf("UpdateFlash reported phases %v, expected %v", phases, expected)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package ccboot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrOutOfFlash = errors.New("Address range is outside of flash")
+
+// Geometry describes the flash of a device
+type Geometry struct {
+	FlashBase  uint32
+	FlashSize  uint32
+	SectorSize uint32
+}
+
+// valid reports whether the flash divides into whole sectors
+func (g Geometry) valid() bool {
+	return g.SectorSize != 0 && g.FlashSize%g.SectorSize == 0 &&
+		uint64(g.FlashBase)+uint64(g.FlashSize) <= 1<<32
+}
+
+// Contains reports whether size bytes at address lie within flash
+func (g Geometry) Contains(address, size uint32) bool {
+	start := uint64(g.FlashBase)
+	end := start + uint64(g.FlashSize)
+	return uint64(address) >= start && uint64(address)+uint64(size) <= end
+}
+
+// SectorAddress returns the address of the sector holding address
+func (g Geometry) SectorAddress(address uint32) uint32 {
+	return address - (address-g.FlashBase)%g.SectorSize
+}
+
+// Range is size bytes starting at Address
+type Range struct {
+	Address uint32
+	Size    uint32
+}
+
+// End returns the address just past the range
+func (r Range) End() uint64 {
+	return uint64(r.Address) + uint64(r.Size)
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("0x%.8X-0x%.8X", r.Address, r.End()-1)
+}
+
+// PartialSector is a sector that the planned ranges only partly cover.
+// Erasing it also erases whatever else it holds.
+type PartialSector struct {
+	Address uint32
+	// Covered is the number of bytes of the sector in the ranges
+	Covered uint32
+}
+
+// ErasePlan lists the sectors to erase for a set of ranges
+type ErasePlan struct {
+	// Sectors holds the address of each sector to erase, in order
+	Sectors []uint32
+	// Partial lists the sectors that hold data outside of the ranges
+	Partial []PartialSector
+	// SectorSize is the size of each sector
+	SectorSize uint32
+}
+
+// PlanErase returns the fewest sectors that must be erased so that all
+// of ranges can be programmed. Empty ranges are ignored, and a range
+// that is not entirely within flash is an error matching ErrOutOfFlash.
+func PlanErase(g Geometry, ranges []Range) (ErasePlan, error) {
+	plan := ErasePlan{SectorSize: g.SectorSize}
+	if !g.valid() {
+		return plan, ErrBadArguments
+	}
+	covered := make(map[uint32]uint32)
+	for _, r := range mergeRanges(ranges) {
+		if !g.Contains(r.Address, r.Size) || r.End() > 1<<32 {
+			return plan, fmt.Errorf("%w: %v", ErrOutOfFlash, r)
+		}
+		for sector := uint64(g.SectorAddress(r.Address)); sector < r.End(); sector += uint64(g.SectorSize) {
+			start, end := sector, sector+uint64(g.SectorSize)
+			if start < uint64(r.Address) {
+				start = uint64(r.Address)
+			}
+			if end > r.End() {
+				end = r.End()
+			}
+			covered[uint32(sector)] += uint32(end - start)
+		}
+	}
+
+	for sector := range covered {
+		plan.Sectors = append(plan.Sectors, sector)
+	}
+	sort.Slice(plan.Sectors, func(i, j int) bool { return plan.Sectors[i] < plan.Sectors[j] })
+	for _, sector := range plan.Sectors {
+		if covered[sector] < g.SectorSize {
+			plan.Partial = append(plan.Partial, PartialSector{sector, covered[sector]})
+		}
+	}
+	return plan, nil
+}
+
+// mergeRanges sorts ranges and joins the ones that overlap, dropping
+// empty ones
+func mergeRanges(ranges []Range) []Range {
+	sorted := make([]Range, 0, len(ranges))
+	for _, r := range ranges {
+		if r.Size > 0 {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Address < sorted[j].Address })
+
+	var merged []Range
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && uint64(r.Address) <= merged[n-1].End() {
+			if r.End() > merged[n-1].End() {
+				merged[n-1].Size = uint32(r.End() - uint64(merged[n-1].Address))
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// Erase erases every sector of plan, checking the status of each. The
+// plan's sector size must be the device's, as given by SectorSize.
+func (d *Device) Erase(plan ErasePlan) error {
+	if err := d.checkSectorSize(plan.SectorSize); err != nil {
+		return err
+	}
+	prog := d.startProgress(len(plan.Sectors) * int(plan.SectorSize))
+	dc := d.checked()
+	for _, sector := range plan.Sectors {
+		if err := dc.SectorErase(sector); err != nil {
+			return &FlashError{Address: sector, Err: err}
+		}
+		prog.report(PhaseErase, sector, int(plan.SectorSize))
+	}
+	return nil
+}
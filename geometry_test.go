@@ -0,0 +1,83 @@
+package ccboot_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+	"github.com/openchirp/ccboot/ccboottest"
+)
+
+var cc2650Geometry = ccboot.Geometry{FlashBase: 0x0, FlashSize: 0x20000, SectorSize: 0x1000}
+
+func TestPlanErase(t *testing.T) {
+	plan, err := ccboot.PlanErase(cc2650Geometry, []ccboot.Range{
+		{Address: 0x0, Size: 0x3C},      // .resetVecs
+		{Address: 0x5EC, Size: 0x3BF4},  // .text
+		{Address: 0x1FFA8, Size: 0x58},  // .ccfg
+		{Address: 0x2000, Size: 0x1000}, // overlaps .text
+		{Address: 0x8000, Size: 0},
+	})
+	if err != nil {
+		t.Fatalf("Error planning erase: %v", err)
+	}
+	expected := []uint32{0x0, 0x1000, 0x2000, 0x3000, 0x4000, 0x1F000}
+	if len(plan.Sectors) != len(expected) {
+		t.Fatalf("Planned sectors %X, expected %X", plan.Sectors, expected)
+	}
+	for i := range expected {
+		if plan.Sectors[i] != expected[i] {
+			t.Fatalf("Planned sectors %X, expected %X", plan.Sectors, expected)
+		}
+	}
+
+	partial := []ccboot.PartialSector{
+		{Address: 0x0, Covered: 0x3C + 0x1000 - 0x5EC},
+		{Address: 0x4000, Covered: 0x1E0},
+		{Address: 0x1F000, Covered: 0x58},
+	}
+	if len(plan.Partial) != len(partial) {
+		t.Fatalf("Partial sectors are %+v, expected %+v", plan.Partial, partial)
+	}
+	for i := range partial {
+		if plan.Partial[i] != partial[i] {
+			t.Errorf("Partial sectors are %+v, expected %+v", plan.Partial, partial)
+		}
+	}
+}
+
+func TestPlanEraseOutOfFlash(t *testing.T) {
+	for _, r := range []ccboot.Range{
+		{Address: 0x1FF00, Size: 0x200},
+		{Address: 0x20000000, Size: 4},
+		{Address: 0xFFFFFFFF, Size: 2},
+	} {
+		if _, err := ccboot.PlanErase(cc2650Geometry, []ccboot.Range{r}); !errors.Is(err, ccboot.ErrOutOfFlash) {
+			t.Errorf("Planning %v returned %v, expected %v", r, err, ccboot.ErrOutOfFlash)
+		}
+	}
+}
+
+func TestErase(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	data := testImage(0x5000)
+	b.LoadFlash(0x0, data)
+
+	plan, err := ccboot.PlanErase(cc2650Geometry, []ccboot.Range{{Address: 0x1800, Size: 0x1000}})
+	if err != nil {
+		t.Fatalf("Error planning erase: %v", err)
+	}
+	if err := d.Erase(plan); err != nil {
+		t.Fatalf("Error erasing: %v", err)
+	}
+	flash := b.Flash()
+	for addr := 0; addr < 0x5000; addr++ {
+		shouldErase := addr >= 0x1000 && addr < 0x3000
+		if erased := flash[addr] == 0xFF; shouldErase && !erased {
+			t.Fatalf("Flash byte at 0x%X was not erased", addr)
+		}
+		if !shouldErase && flash[addr] == 0xFF && data[addr] != 0xFF {
+			t.Fatalf("Flash byte at 0x%X was erased", addr)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package ccboot
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Intel HEX record types
+const (
+	ihexData             = 0x00
+	ihexEOF              = 0x01
+	ihexExtSegmentAddr   = 0x02
+	ihexStartSegmentAddr = 0x03
+	ihexExtLinearAddr    = 0x04
+	ihexStartLinearAddr  = 0x05
+)
+
+// ihexLineSize is the number of data bytes WriteIntelHex puts on a line
+const ihexLineSize = 16
+
+// ParseError reports a malformed line of a firmware file. It matches
+// ErrParse.
+type ParseError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+func (e *ParseError) Unwrap() error {
+	return ErrParse
+}
+
+// ReadIntelHex parses an Intel HEX file. Extended segment and extended
+// linear address records are applied to the data records that follow,
+// and a start segment or start linear address record sets the image's
+// entry point.
+func ReadIntelHex(r io.Reader) (*Image, error) {
+	img := new(Image)
+	base := uint32(0)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			continue
+		}
+		if text[0] != ':' {
+			return nil, &ParseError{line, "record does not start with ':'"}
+		}
+		rec, err := hex.DecodeString(text[1:])
+		if err != nil {
+			return nil, &ParseError{line, "invalid hex digits"}
+		}
+		if len(rec) < 5 || len(rec) != 5+int(rec[0]) {
+			return nil, &ParseError{line, "record length does not match its byte count"}
+		}
+		if sum := checksum(rec); sum != 0 {
+			return nil, &ParseError{line, fmt.Sprintf("bad checksum 0x%.2X", rec[len(rec)-1])}
+		}
+		offset := uint32(rec[1])<<8 | uint32(rec[2])
+		data := rec[4 : len(rec)-1]
+
+		switch rec[3] {
+		case ihexData:
+			if err := img.Add(base+offset, data); err != nil {
+				return nil, &ParseError{line, err.Error()}
+			}
+		case ihexEOF:
+			return img, nil
+		case ihexExtSegmentAddr:
+			if len(data) != 2 {
+				return nil, &ParseError{line, "extended segment address record must hold 2 bytes"}
+			}
+			base = decodeUint32(data) << 4
+		case ihexExtLinearAddr:
+			if len(data) != 2 {
+				return nil, &ParseError{line, "extended linear address record must hold 2 bytes"}
+			}
+			base = decodeUint32(data) << 16
+		case ihexStartSegmentAddr:
+			if len(data) != 4 {
+				return nil, &ParseError{line, "start segment address record must hold 4 bytes"}
+			}
+			cs, ip := decodeUint32(data[0:2]), decodeUint32(data[2:4])
+			img.Entry, img.HasEntry = cs<<4+ip, true
+		case ihexStartLinearAddr:
+			if len(data) != 4 {
+				return nil, &ParseError{line, "start linear address record must hold 4 bytes"}
+			}
+			img.Entry, img.HasEntry = decodeUint32(data), true
+		default:
+			return nil, &ParseError{line, fmt.Sprintf("unknown record type 0x%.2X", rec[3])}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, &ParseError{line, "missing end of file record"}
+}
+
+// WriteIntelHex writes img as an Intel HEX file, using extended linear
+// address records for addresses above 64K
+func WriteIntelHex(w io.Writer, img *Image) error {
+	bw := bufio.NewWriter(w)
+	upper := uint32(0)
+	for _, seg := range img.Segments {
+		for off := 0; off < len(seg.Data); {
+			address := seg.Address + uint32(off)
+			if address>>16 != upper {
+				upper = address >> 16
+				writeIhexRecord(bw, ihexExtLinearAddr, 0, []byte{byte(upper >> 8), byte(upper)})
+			}
+			// a record may not cross into the next 64K
+			n := len(seg.Data) - off
+			if n > ihexLineSize {
+				n = ihexLineSize
+			}
+			if room := 0x10000 - int(address&0xFFFF); n > room {
+				n = room
+			}
+			writeIhexRecord(bw, ihexData, uint16(address), seg.Data[off:off+n])
+			off += n
+		}
+	}
+	if img.HasEntry {
+		e := img.Entry
+		writeIhexRecord(bw, ihexStartLinearAddr, 0, []byte{byte(e >> 24), byte(e >> 16), byte(e >> 8), byte(e)})
+	}
+	writeIhexRecord(bw, ihexEOF, 0, nil)
+	return bw.Flush()
+}
+
+func writeIhexRecord(w *bufio.Writer, typ byte, offset uint16, data []byte) {
+	rec := make([]byte, 0, 5+len(data))
+	rec = append(rec, byte(len(data)), byte(offset>>8), byte(offset), typ)
+	rec = append(rec, data...)
+	rec = append(rec, -checksum(rec))
+	fmt.Fprintf(w, ":%s\n", strings.ToUpper(hex.EncodeToString(rec)))
+}
@@ -0,0 +1,100 @@
+package ccboot_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+	"github.com/openchirp/ccboot/ccboottest"
+)
+
+const sampleIntelHex = `:020000040001F9
+:10000000214601360121470136007EFE09D2190141
+:04001000FFFFFFFFF0
+:020000022000DC
+:0400000001020304F2
+:0400000500000101F5
+:00000001FF
+`
+
+func TestReadIntelHex(t *testing.T) {
+	img, err := ccboot.ReadIntelHex(strings.NewReader(sampleIntelHex))
+	if err != nil {
+		t.Fatalf("Error parsing Intel HEX: %v", err)
+	}
+	if len(img.Segments) != 2 {
+		t.Fatalf("Image has %d segments, expected 2", len(img.Segments))
+	}
+	if seg := img.Segments[0]; seg.Address != 0x10000 || len(seg.Data) != 0x14 || seg.Data[0] != 0x21 || seg.Data[0x13] != 0xFF {
+		t.Errorf("First segment is %+v", seg)
+	}
+	if seg := img.Segments[1]; seg.Address != 0x20000 || !bytes.Equal(seg.Data, []byte{1, 2, 3, 4}) {
+		t.Errorf("Second segment is %+v", seg)
+	}
+	if !img.HasEntry || img.Entry != 0x101 {
+		t.Errorf("Entry is 0x%X (%v), expected 0x101", img.Entry, img.HasEntry)
+	}
+}
+
+func TestReadIntelHexErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		line int
+	}{
+		{":020000040001F9\n:10000000214601360121470136007EFE09D2190140\n", 2},
+		{":020000040001F9\n\n0400000001020304F2\n", 3},
+		{":0400000001020304\n", 1},
+		{":0400000001020304F2\n", 1},
+		{":0200000601020304F2\n", 1},
+	}
+	for _, tt := range tests {
+		_, err := ccboot.ReadIntelHex(strings.NewReader(tt.text))
+		var parseErr *ccboot.ParseError
+		if !errors.As(err, &parseErr) || !errors.Is(err, ccboot.ErrParse) {
+			t.Errorf("Parsing %q returned %v, expected a *ParseError", tt.text, err)
+			continue
+		}
+		if parseErr.Line != tt.line {
+			t.Errorf("Parsing %q failed on line %d, expected %d: %v", tt.text, parseErr.Line, tt.line, err)
+		}
+	}
+}
+
+func TestWriteIntelHex(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	data := testImage(0x300)
+	b.LoadFlash(0xFF00, data)
+
+	// save a dump that crosses a 64K boundary and read it back
+	dump, err := d.ReadMemory(0xFF00, uint32(len(data)))
+	if err != nil {
+		t.Fatalf("Error reading memory: %v", err)
+	}
+	img := ccboot.NewImage(0xFF00, dump)
+	img.Entry, img.HasEntry = 0x1234, true
+	var buf bytes.Buffer
+	if err := ccboot.WriteIntelHex(&buf, img); err != nil {
+		t.Fatalf("Error writing Intel HEX: %v", err)
+	}
+	got, err := ccboot.ReadIntelHex(&buf)
+	if err != nil {
+		t.Fatalf("Error parsing written Intel HEX: %v", err)
+	}
+	if len(got.Segments) != 1 || got.Segments[0].Address != 0xFF00 || !bytes.Equal(got.Segments[0].Data, data) {
+		t.Errorf("Read back %d segments, expected the dump", len(got.Segments))
+	}
+	if !got.HasEntry || got.Entry != 0x1234 {
+		t.Errorf("Entry is 0x%X (%v), expected 0x1234", got.Entry, got.HasEntry)
+	}
+
+	// flash the parsed image somewhere else
+	got.Segments[0].Address = 0x4000
+	if err := d.WriteImage(got); err != nil {
+		t.Fatalf("Error writing image: %v", err)
+	}
+	if !bytes.Equal(b.Flash()[0x4000:0x4000+len(data)], data) {
+		t.Error("Flash contents do not match the image")
+	}
+}
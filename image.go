@@ -0,0 +1,242 @@
+package ccboot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrOverlap = errors.New("Image segments overlap with different data")
+
+// ConflictError reports the first address at which two pieces of an
+// image disagree. It matches ErrOverlap.
+type ConflictError struct {
+	Address uint32
+	Old     byte
+	New     byte
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v at 0x%.8X: 0x%.2X != 0x%.2X", ErrOverlap, e.Address, e.Old, e.New)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrOverlap
+}
+
+// Segment is a contiguous run of bytes at an address
+type Segment struct {
+	Address uint32
+	Data    []byte
+}
+
+// End returns the address just past the segment
+func (s Segment) End() uint64 {
+	return uint64(s.Address) + uint64(len(s.Data))
+}
+
+// Image is firmware as a sparse set of bytes at addresses
+type Image struct {
+	// Segments are kept sorted by address and never overlap or touch
+	Segments []Segment
+	// Entry is the start address given by the firmware file, if
+	// HasEntry is set
+	Entry    uint32
+	HasEntry bool
+}
+
+// NewImage creates an image holding data at address
+func NewImage(address uint32, data []byte) *Image {
+	img := new(Image)
+	img.Add(address, data)
+	return img
+}
+
+// Add inserts a copy of data at address, joining it with segments that
+// it overlaps or touches. Overlapping data must match what the image
+// already holds, otherwise a *ConflictError is returned and the image
+// is unchanged.
+func (img *Image) Add(address uint32, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	end := uint64(address) + uint64(len(data))
+	if end > 1<<32 {
+		return ErrBadArguments
+	}
+
+	// segments[i:j] overlap or touch the new data
+	i := sort.Search(len(img.Segments), func(i int) bool {
+		return img.Segments[i].End() >= uint64(address)
+	})
+	j := i
+	for j < len(img.Segments) && uint64(img.Segments[j].Address) <= end {
+		j++
+	}
+
+	start := uint64(address)
+	stop := end
+	for _, seg := range img.Segments[i:j] {
+		for a := maxUint64(start, uint64(seg.Address)); a < minUint64(end, seg.End()); a++ {
+			old, v := seg.Data[a-uint64(seg.Address)], data[a-start]
+			if old != v {
+				return &ConflictError{uint32(a), old, v}
+			}
+		}
+	}
+	if i < j {
+		start = minUint64(start, uint64(img.Segments[i].Address))
+		stop = maxUint64(stop, img.Segments[j-1].End())
+	}
+
+	merged := make([]byte, stop-start)
+	for _, seg := range img.Segments[i:j] {
+		copy(merged[uint64(seg.Address)-start:], seg.Data)
+	}
+	copy(merged[uint64(address)-start:], data)
+
+	segments := append([]Segment(nil), img.Segments[:i]...)
+	segments = append(segments, Segment{uint32(start), merged})
+	img.Segments = append(segments, img.Segments[j:]...)
+	return nil
+}
+
+// Size returns the number of bytes the image holds
+func (img *Image) Size() int {
+	size := 0
+	for _, seg := range img.Segments {
+		size += len(seg.Data)
+	}
+	return size
+}
+
+// Ranges returns the address range of each segment, for PlanErase
+func (img *Image) Ranges() []Range {
+	ranges := make([]Range, len(img.Segments))
+	for i, seg := range img.Segments {
+		ranges[i] = Range{seg.Address, uint32(len(seg.Data))}
+	}
+	return ranges
+}
+
+// Clone returns a deep copy of img
+func (img *Image) Clone() *Image {
+	c := &Image{Entry: img.Entry, HasEntry: img.HasEntry}
+	for _, seg := range img.Segments {
+		c.Segments = append(c.Segments, Segment{seg.Address, append([]byte(nil), seg.Data...)})
+	}
+	return c
+}
+
+// Fill sets every address of r that the image does not hold to value,
+// so that r becomes a single segment
+func (img *Image) Fill(r Range, value byte) error {
+	filler := make([]byte, r.Size)
+	for i := range filler {
+		filler[i] = value
+	}
+	for _, seg := range img.Segments {
+		start := maxUint64(uint64(seg.Address), uint64(r.Address))
+		end := minUint64(seg.End(), r.End())
+		if start < end {
+			copy(filler[start-uint64(r.Address):], seg.Data[start-uint64(seg.Address):end-uint64(seg.Address)])
+		}
+	}
+	// the filler agrees with every segment, so it can not conflict, but
+	// r may run past the end of the address space
+	return img.Add(r.Address, filler)
+}
+
+// Crop drops every byte of the image outside of r
+func (img *Image) Crop(r Range) {
+	var segments []Segment
+	for _, seg := range img.Segments {
+		start := maxUint64(uint64(seg.Address), uint64(r.Address))
+		end := minUint64(seg.End(), r.End())
+		if start < end {
+			data := seg.Data[start-uint64(seg.Address) : end-uint64(seg.Address)]
+			segments = append(segments, Segment{uint32(start), data})
+		}
+	}
+	img.Segments = segments
+}
+
+// Split returns the image's segments cut at every multiple of
+// sectorSize, so that no segment spans two sectors
+func (img *Image) Split(sectorSize uint32) ([]Segment, error) {
+	if sectorSize == 0 {
+		return nil, ErrBadArguments
+	}
+	var segments []Segment
+	for _, seg := range img.Segments {
+		for off := uint64(0); off < uint64(len(seg.Data)); {
+			address := uint64(seg.Address) + off
+			n := uint64(sectorSize) - address%uint64(sectorSize)
+			if n > uint64(len(seg.Data))-off {
+				n = uint64(len(seg.Data)) - off
+			}
+			segments = append(segments, Segment{uint32(address), seg.Data[off : off+n]})
+			off += n
+		}
+	}
+	return segments, nil
+}
+
+// MergeImages combines images, such as a bootloader, an application and
+// a configuration block, into one. Bytes that more than one image holds
+// must agree. The entry point is taken from the first image that has
+// one.
+func MergeImages(images ...*Image) (*Image, error) {
+	merged := new(Image)
+	for _, img := range images {
+		for _, seg := range img.Segments {
+			if err := merged.Add(seg.Address, seg.Data); err != nil {
+				return nil, err
+			}
+		}
+		if img.HasEntry && !merged.HasEntry {
+			merged.Entry, merged.HasEntry = img.Entry, true
+		}
+	}
+	return merged, nil
+}
+
+// WriteImage programs every segment of img with WriteFlash. The CCFG
+// the image holds is checked before any segment is programmed.
+func (d *Device) WriteImage(img *Image) error {
+	if err := d.checkLockout(img.Segments...); err != nil {
+		return err
+	}
+	prog := d.startProgress(img.Size())
+	for _, seg := range img.Segments {
+		if err := d.writeFlash(seg.Address, seg.Data, prog); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyImage checks every segment of img with VerifyFlash
+func (d *Device) VerifyImage(img *Image) error {
+	prog := d.startProgress(img.Size())
+	for _, seg := range img.Segments {
+		if err := d.verifyFlash(seg.Address, seg.Data, prog); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
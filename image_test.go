@@ -0,0 +1,130 @@
+package ccboot_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+	"github.com/openchirp/ccboot/ccboottest"
+)
+
+func TestImageAdd(t *testing.T) {
+	img := new(ccboot.Image)
+	for _, seg := range []ccboot.Segment{
+		{Address: 0x10, Data: []byte{3, 4}},
+		{Address: 0x20, Data: []byte{9}},
+		{Address: 0x0E, Data: []byte{1, 2}},
+		{Address: 0x12, Data: []byte{5}},
+	} {
+		if err := img.Add(seg.Address, seg.Data); err != nil {
+			t.Fatalf("Error adding %+v: %v", seg, err)
+		}
+	}
+	if len(img.Segments) != 2 {
+		t.Fatalf("Image has segments %+v, expected 2", img.Segments)
+	}
+	if seg := img.Segments[0]; seg.Address != 0x0E || !bytes.Equal(seg.Data, []byte{1, 2, 3, 4, 5}) {
+		t.Errorf("First segment is %+v", seg)
+	}
+	if img.Size() != 6 {
+		t.Errorf("Image size is %d, expected 6", img.Size())
+	}
+
+	// overlapping data that agrees is merged
+	if err := img.Add(0x11, []byte{4, 5, 6}); err != nil {
+		t.Fatalf("Error adding matching overlap: %v", err)
+	}
+	if seg := img.Segments[0]; !bytes.Equal(seg.Data, []byte{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("First segment is %+v after matching overlap", seg)
+	}
+
+	err := img.Add(0x1F, []byte{0, 0})
+	var conflict *ccboot.ConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, ccboot.ErrOverlap) {
+		t.Fatalf("Adding conflicting data returned %v, expected a *ConflictError", err)
+	}
+	if conflict.Address != 0x20 || conflict.Old != 9 || conflict.New != 0 {
+		t.Errorf("Conflict is %+v", conflict)
+	}
+	if len(img.Segments) != 2 || img.Size() != 7 {
+		t.Errorf("Image changed after a conflict: %+v", img.Segments)
+	}
+}
+
+func TestImageFillCropSplit(t *testing.T) {
+	img := ccboot.NewImage(0x0FFE, []byte{1, 2, 3, 4})
+	img.Add(0x1FFE, []byte{5, 6})
+
+	if err := img.Fill(ccboot.Range{Address: 0x0F00, Size: 0x1200}, 0xFF); err != nil {
+		t.Fatalf("Error filling image: %v", err)
+	}
+	if len(img.Segments) != 1 || img.Segments[0].Address != 0x0F00 || img.Size() != 0x1200 {
+		t.Fatalf("Filled image has segments at %v", img.Ranges())
+	}
+	if data := img.Segments[0].Data; data[0] != 0xFF || data[0x0FE] != 1 || data[0x10FF] != 6 {
+		t.Errorf("Filled image lost data")
+	}
+
+	img.Crop(ccboot.Range{Address: 0x1000, Size: 0x1000})
+	if len(img.Segments) != 1 || img.Segments[0].Address != 0x1000 || img.Size() != 0x1000 {
+		t.Fatalf("Cropped image has segments at %v", img.Ranges())
+	}
+	if data := img.Segments[0].Data; data[0] != 3 || data[0xFFE] != 5 {
+		t.Errorf("Cropped image lost data")
+	}
+
+	img.Add(0x2000, []byte{7, 8})
+	segments, err := img.Split(0x800)
+	if err != nil {
+		t.Fatalf("Error splitting image: %v", err)
+	}
+	expected := []ccboot.Range{{Address: 0x1000, Size: 0x800}, {Address: 0x1800, Size: 0x800}, {Address: 0x2000, Size: 2}}
+	if len(segments) != len(expected) {
+		t.Fatalf("Split into %d segments, expected %d", len(segments), len(expected))
+	}
+	for i, r := range expected {
+		if segments[i].Address != r.Address || len(segments[i].Data) != int(r.Size) {
+			t.Errorf("Segment %d is at 0x%X with %d bytes, expected %v", i, segments[i].Address, len(segments[i].Data), r)
+		}
+	}
+
+	if _, err := img.Split(0); err != ccboot.ErrBadArguments {
+		t.Errorf("Split with no sector size returned %v, expected %v", err, ccboot.ErrBadArguments)
+	}
+	if err := img.Fill(ccboot.Range{Address: 0xFFFFFF00, Size: 0x200}, 0xFF); err != ccboot.ErrBadArguments {
+		t.Errorf("Fill past the end of the address space returned %v, expected %v", err, ccboot.ErrBadArguments)
+	}
+}
+
+func TestMergeImages(t *testing.T) {
+	boot := ccboot.NewImage(0x0, []byte{1, 2, 3, 4})
+	app := ccboot.NewImage(0x1000, testImage(0x100))
+	app.Entry, app.HasEntry = 0x1001, true
+	ccfg := ccboot.NewImage(0x1FFA8, unlockedCCFG())
+
+	img, err := ccboot.MergeImages(boot, app, ccfg)
+	if err != nil {
+		t.Fatalf("Error merging images: %v", err)
+	}
+	if len(img.Segments) != 3 || img.Size() != 4+0x100+0x58 || img.Entry != 0x1001 {
+		t.Errorf("Merged image has segments at %v and entry 0x%X", img.Ranges(), img.Entry)
+	}
+
+	bad := ccboot.NewImage(0x2, []byte{0, 0})
+	if _, err := ccboot.MergeImages(boot, bad); !errors.Is(err, ccboot.ErrOverlap) {
+		t.Errorf("Merging conflicting images returned %v, expected %v", err, ccboot.ErrOverlap)
+	}
+
+	d, _ := newSimDevice(t, ccboottest.DefaultConfig)
+	if err := d.WriteImage(img); err != nil {
+		t.Fatalf("Error writing image: %v", err)
+	}
+	if err := d.VerifyImage(img); err != nil {
+		t.Fatalf("Error verifying image: %v", err)
+	}
+	img.Segments[1].Data[0] ^= 0xFF
+	if err := d.VerifyImage(img); !errors.Is(err, ccboot.ErrVerify) {
+		t.Errorf("Verifying a changed image returned %v, expected %v", err, ccboot.ErrVerify)
+	}
+}
@@ -0,0 +1,214 @@
+package ccboot
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Family is a group of parts that share a bootloader and register layout
+type Family byte
+
+const (
+	FamilyUnknown = Family(0)
+	FamilyCC2538  = Family(1)
+	FamilyCC26x0  = Family(2)
+	FamilyCC13x0  = Family(3)
+	// FamilyCC26x2 covers the CC26x2 and the CC13x2, which share a die
+	FamilyCC26x2 = Family(4)
+)
+
+var family2String = map[Family]string{
+	FamilyUnknown: "unknown",
+	FamilyCC2538:  "CC2538",
+	FamilyCC26x0:  "CC26x0",
+	FamilyCC13x0:  "CC13x0",
+	FamilyCC26x2:  "CC13x2/CC26x2",
+}
+
+func (f Family) String() string {
+	if str, ok := family2String[f]; ok {
+		return str
+	}
+	return fmt.Sprintf("0x%X", byte(f))
+}
+
+// Registers read by Probe
+const (
+	// CC13xx/CC26xx factory configuration (FCFG1)
+	regUserID          = 0x50001294
+	regMACBLE0         = 0x500012E8
+	regMAC154_0        = 0x500012F0
+	regICEPickDeviceID = 0x50001318
+	regFlashSize       = 0x4003002C
+
+	// CC2538 system control and info page
+	regDieCfg0     = 0x400D3014
+	regDieCfg2     = 0x400D301C
+	cc2538IEEEAddr = 0x00280028
+)
+
+// Wafer IDs found in ICEPICK_DEVICE_ID
+var waferID2Family = map[uint32]Family{
+	0xB99A: FamilyCC26x0,
+	0xB9BE: FamilyCC13x0,
+	0xBB41: FamilyCC26x2,
+}
+
+// cc26xxPackages names the PKG field of the FCFG1 USER_ID
+var cc26xxPackages = map[uint32]string{
+	0: "4x4mm QFN",
+	1: "5x5mm QFN",
+	2: "7x7mm QFN",
+	3: "wafer",
+	4: "2.7x2.7mm WCSP",
+	5: "7x7mm QFN (Q1)",
+}
+
+// DeviceInfo describes the connected chip, as found by Probe
+type DeviceInfo struct {
+	// ChipID is the value returned by GetChipID
+	ChipID uint32
+	Family Family
+	// WaferID identifies the CC13xx/CC26xx die
+	WaferID uint32
+	// Revision is the silicon revision
+	Revision uint8
+	// Package is the chip package, when the family records it
+	Package string
+	// FlashSize is the size of flash in bytes
+	FlashSize uint32
+	// IEEEAddress is the factory IEEE 802.15.4 address
+	IEEEAddress net.HardwareAddr
+	// BLEAddress is the factory BLE address, on families with BLE
+	BLEAddress net.HardwareAddr
+}
+
+func (info *DeviceInfo) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Family:       %v\n", info.Family)
+	fmt.Fprintf(&b, "Chip ID:      0x%.8X\n", info.ChipID)
+	if info.WaferID != 0 {
+		fmt.Fprintf(&b, "Wafer ID:     0x%.4X\n", info.WaferID)
+	}
+	fmt.Fprintf(&b, "Revision:     %d\n", info.Revision)
+	if info.Package != "" {
+		fmt.Fprintf(&b, "Package:      %s\n", info.Package)
+	}
+	fmt.Fprintf(&b, "Flash size:   %d KB\n", info.FlashSize/1024)
+	if info.IEEEAddress != nil {
+		fmt.Fprintf(&b, "IEEE address: %v\n", info.IEEEAddress)
+	}
+	if info.BLEAddress != nil {
+		fmt.Fprintf(&b, "BLE address:  %v\n", info.BLEAddress)
+	}
+	return b.String()
+}
+
+// Probe identifies the connected chip and reads its flash size,
+// silicon revision, package and factory MAC addresses
+func (d *Device) Probe() (*DeviceInfo, error) {
+	chipID, err := d.GetChipID()
+	if err != nil {
+		return nil, err
+	}
+	info := &DeviceInfo{ChipID: chipID}
+	if d.Variant() == VariantCC2538 {
+		info.Family = FamilyCC2538
+		d.state.detected = true
+		return info, d.probeCC2538(info)
+	}
+	return info, d.probeCC26xx(info)
+}
+
+// readWord reads a 32 bit register
+func (d *Device) readWord(address uint32) (uint32, error) {
+	data, err := d.MemoryRead(address, ReadWriteType32Bit, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 4 {
+		return 0, d.badResponse()
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+func (d *Device) probeCC26xx(info *DeviceInfo) error {
+	icepick, err := d.readWord(regICEPickDeviceID)
+	if err != nil {
+		return err
+	}
+	info.WaferID = (icepick >> 12) & 0xFFFF
+	info.Revision = uint8(icepick >> 28)
+	info.Family = waferID2Family[info.WaferID]
+	d.state.variant = VariantForFamily(info.Family)
+	d.state.detected = true
+
+	userID, err := d.readWord(regUserID)
+	if err != nil {
+		return err
+	}
+	info.Package = cc26xxPackages[(userID>>16)&0x7]
+
+	sectors, err := d.readWord(regFlashSize)
+	if err != nil {
+		return err
+	}
+	info.FlashSize = flashSize(sectors, VariantForFamily(info.Family))
+	d.state.flashSize = info.FlashSize
+	d.state.flashSizeKnown = true
+
+	// the MAC registers hold the address least significant byte first
+	mac, err := d.ReadMemory(regMAC154_0, 8)
+	if err != nil {
+		return err
+	}
+	info.IEEEAddress = reverse(mac)
+	ble, err := d.ReadMemory(regMACBLE0, 6)
+	if err != nil {
+		return err
+	}
+	info.BLEAddress = reverse(ble)
+	return nil
+}
+
+func (d *Device) probeCC2538(info *DeviceInfo) error {
+	diecfg0, err := d.readWord(regDieCfg0)
+	if err != nil {
+		return err
+	}
+	info.FlashSize = ((diecfg0 >> 4) & 0x7) * 128 * 1024
+
+	diecfg2, err := d.readWord(regDieCfg2)
+	if err != nil {
+		return err
+	}
+	info.Revision = uint8((diecfg2>>12)&0xF)<<4 | uint8((diecfg2>>8)&0xF)
+
+	// the info page holds the address as two words, the upper one second
+	ieee, err := d.ReadMemory(cc2538IEEEAddr, 8)
+	if err != nil {
+		return err
+	}
+	info.IEEEAddress = append(net.HardwareAddr(nil), ieee[4:8]...)
+	info.IEEEAddress = append(info.IEEEAddress, ieee[0:4]...)
+	return nil
+}
+
+// flashSize decodes the FLASH_SIZE register of a CC13xx/CC26xx, which
+// counts sectors of 8 KB on the CC13x2/CC26x2 and 4 KB otherwise
+func flashSize(sectors uint32, v *Variant) uint32 {
+	if v == VariantCC26x2 {
+		return (sectors & 0xFF) * 0x2000
+	}
+	return (sectors & 0xFF) * 0x1000
+}
+
+func reverse(data []byte) net.HardwareAddr {
+	out := make(net.HardwareAddr, len(data))
+	for i, v := range data {
+		out[len(data)-1-i] = v
+	}
+	return out
+}
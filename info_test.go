@@ -0,0 +1,84 @@
+package ccboot_test
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+	"github.com/openchirp/ccboot/ccboottest"
+)
+
+func le32(v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, v)
+	return buf
+}
+
+func TestProbeCC26x0(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	b.SetMemory(0x50001318, le32(2<<28|0xB99A<<12|0x02F))
+	b.SetMemory(0x50001294, le32(1<<16))
+	b.SetMemory(0x4003002C, le32(0x20))
+	b.SetMemory(0x500012F0, []byte{0x08, 0x07, 0x06, 0x05, 0x04, 0x4B, 0x12, 0x00})
+	b.SetMemory(0x500012E8, []byte{0x16, 0x15, 0x14, 0x13, 0x12, 0x11})
+
+	info, err := d.Probe()
+	if err != nil {
+		t.Fatalf("Error probing: %v", err)
+	}
+	if info.Family != ccboot.FamilyCC26x0 {
+		t.Errorf("Family is %v, expected %v", info.Family, ccboot.FamilyCC26x0)
+	}
+	if info.ChipID != ccboottest.DefaultConfig.ChipID {
+		t.Errorf("Chip ID is 0x%X, expected 0x%X", info.ChipID, ccboottest.DefaultConfig.ChipID)
+	}
+	if info.WaferID != 0xB99A {
+		t.Errorf("Wafer ID is 0x%X, expected 0xB99A", info.WaferID)
+	}
+	if info.Revision != 2 {
+		t.Errorf("Revision is %d, expected 2", info.Revision)
+	}
+	if info.Package != "5x5mm QFN" {
+		t.Errorf("Package is %q, expected %q", info.Package, "5x5mm QFN")
+	}
+	if info.FlashSize != 0x20000 {
+		t.Errorf("Flash size is 0x%X, expected 0x20000", info.FlashSize)
+	}
+	if s := info.IEEEAddress.String(); s != "00:12:4b:04:05:06:07:08" {
+		t.Errorf("IEEE address is %s, expected 00:12:4b:04:05:06:07:08", s)
+	}
+	if s := info.BLEAddress.String(); s != "11:12:13:14:15:16" {
+		t.Errorf("BLE address is %s, expected 11:12:13:14:15:16", s)
+	}
+	if s := info.String(); !strings.Contains(s, "Flash size:   128 KB") {
+		t.Errorf("Device info does not list the flash size:\n%s", s)
+	}
+}
+
+func TestProbeCC2538(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.CC2538Config)
+	b.SetMemory(0x400D3014, le32(4<<4))
+	b.SetMemory(0x400D301C, le32(0x2100))
+	b.SetMemory(0x00280028, []byte{0x04, 0x05, 0x06, 0x07, 0x00, 0x12, 0x4B, 0x00})
+
+	info, err := d.Probe()
+	if err != nil {
+		t.Fatalf("Error probing: %v", err)
+	}
+	if info.Family != ccboot.FamilyCC2538 {
+		t.Errorf("Family is %v, expected %v", info.Family, ccboot.FamilyCC2538)
+	}
+	if info.FlashSize != 0x80000 {
+		t.Errorf("Flash size is 0x%X, expected 0x80000", info.FlashSize)
+	}
+	if info.Revision != 0x21 {
+		t.Errorf("Revision is 0x%X, expected 0x21", info.Revision)
+	}
+	if s := info.IEEEAddress.String(); s != "00:12:4b:00:04:05:06:07" {
+		t.Errorf("IEEE address is %s, expected 00:12:4b:00:04:05:06:07", s)
+	}
+	if info.BLEAddress != nil {
+		t.Errorf("CC2538 reported BLE address %s", info.BLEAddress)
+	}
+}
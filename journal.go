@@ -0,0 +1,248 @@
+package ccboot
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SectorState is how far a sector has come in a journaled flash
+type SectorState byte
+
+const (
+	SectorPending    = SectorState(0)
+	SectorErased     = SectorState(1)
+	SectorProgrammed = SectorState(2)
+	SectorVerified   = SectorState(3)
+)
+
+var sectorState2String = map[SectorState]string{
+	SectorPending:    "pending",
+	SectorErased:     "erased",
+	SectorProgrammed: "programmed",
+	SectorVerified:   "verified",
+}
+
+func (s SectorState) String() string {
+	if str, ok := sectorState2String[s]; ok {
+		return str
+	}
+	return fmt.Sprintf("0x%X", byte(s))
+}
+
+// Journal records the progress of FlashWithJournal, so that an
+// interrupted flash can continue where it stopped
+type Journal struct {
+	// Image identifies the image, its address and the sector size
+	Image  string `json:"image"`
+	ChipID uint32 `json:"chipid"`
+	// Sectors holds the state of each sector the image covers, starting
+	// with the one holding the image's first byte
+	Sectors []SectorState `json:"sectors"`
+}
+
+// LoadJournal reads the journal at path
+func LoadJournal(path string) (*Journal, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	j := new(Journal)
+	if err := json.Unmarshal(buf, j); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Save atomically replaces the journal at path
+func (j *Journal) Save(path string) error {
+	buf, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// imageHash identifies an image at address flashed in sectors of
+// sectorSize bytes
+func imageHash(address uint32, data []byte, sectorSize uint32) string {
+	h := sha256.New()
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[0:4], address)
+	binary.BigEndian.PutUint32(header[4:8], sectorSize)
+	h.Write(header[:])
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// FlashWithJournal erases, programs and verifies data at address one
+// sector of sectorSize bytes at a time, recording each step in the
+// journal file at path.
+//
+// If the journal describes the same image and chip, the state it
+// records is first confirmed with CRC32 and flashing continues from the
+// first incomplete sector. Otherwise flashing starts over. Sectors are
+// erased whole, so flash that shares a sector with the image is lost,
+// and sectorSize must be the device's, as given by SectorSize. The
+// journal is removed once every sector has been verified again after
+// the last one was programmed.
+func (d *Device) FlashWithJournal(path string, address uint32, data []byte, sectorSize uint32) error {
+	if sectorSize == 0 || sectorSize%FlashWordSize != 0 {
+		return ErrBadArguments
+	}
+	if uint64(address)+uint64(len(data)) > 1<<32 {
+		return ErrBadArguments
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if err := d.checkSectorSize(sectorSize); err != nil {
+		return err
+	}
+	if err := d.checkLockout(Segment{Address: address, Data: data}); err != nil {
+		return err
+	}
+	chipID, err := d.GetChipID()
+	if err != nil {
+		return err
+	}
+
+	first := address - address%sectorSize
+	count := int((uint64(address) + uint64(len(data)) - uint64(first) + uint64(sectorSize) - 1) / uint64(sectorSize))
+	hash := imageHash(address, data, sectorSize)
+
+	j, err := LoadJournal(path)
+	if err != nil || j.Image != hash || j.ChipID != chipID || len(j.Sectors) != count {
+		j = &Journal{Image: hash, ChipID: chipID, Sectors: make([]SectorState, count)}
+	}
+
+	// image returns the part of data held by sector i and its address
+	image := func(i int) (uint32, []byte) {
+		start := uint64(first) + uint64(i)*uint64(sectorSize)
+		stop := start + uint64(sectorSize)
+		if start < uint64(address) {
+			start = uint64(address)
+		}
+		if end := uint64(address) + uint64(len(data)); stop > end {
+			stop = end
+		}
+		return uint32(start), data[start-uint64(address) : stop-uint64(address)]
+	}
+
+	// confirm the journal's record of the device, up to the first
+	// incomplete sector
+	resume := 0
+	for ; resume < count; resume++ {
+		state := j.Sectors[resume]
+		if state == SectorPending {
+			break
+		}
+		start, part := image(resume)
+		expected := ChecksumCRC32(part, 0)
+		if state == SectorErased {
+			expected = ChecksumCRC32(erased(len(part)), 0)
+		}
+		crc, err := d.CRC32(start, uint32(len(part)), 0)
+		if err != nil {
+			return err
+		}
+		if crc != expected {
+			j.Sectors[resume] = SectorPending
+			break
+		}
+		if state == SectorErased {
+			// continue by programming it
+			break
+		}
+		j.Sectors[resume] = SectorVerified
+	}
+	// everything after the first incomplete sector starts over
+	for i := resume + 1; i < count; i++ {
+		j.Sectors[i] = SectorPending
+	}
+	if err := j.Save(path); err != nil {
+		return err
+	}
+
+	prog := d.startProgress(len(data))
+	dc := d.checked()
+	dc.opts.OnProgress = nil
+	// the image was checked before anything was erased
+	dc.opts.AllowLockout = true
+	for i := 0; i < count; i++ {
+		start, part := image(i)
+		if i < resume {
+			prog.report(PhaseVerify, start, len(part))
+			continue
+		}
+		sector := first + uint32(i)*sectorSize
+		if j.Sectors[i] == SectorPending {
+			if err := dc.SectorErase(sector); err != nil {
+				return flashError(address, 0, int(start-address), err)
+			}
+			prog.report(PhaseErase, sector, 0)
+			j.Sectors[i] = SectorErased
+			if err := j.Save(path); err != nil {
+				return err
+			}
+		}
+		if err := dc.WriteFlash(start, part); err != nil {
+			return flashError(address, 0, int(start-address), err)
+		}
+		prog.report(PhaseProgram, start, len(part))
+		j.Sectors[i] = SectorProgrammed
+		if err := j.Save(path); err != nil {
+			return err
+		}
+		if err := dc.VerifyFlash(start, part); err != nil {
+			return err
+		}
+		j.Sectors[i] = SectorVerified
+		if err := j.Save(path); err != nil {
+			return err
+		}
+	}
+
+	// check the whole image once more, as erasing a sector must not have
+	// touched the ones before it
+	for i := 0; i < count; i++ {
+		start, part := image(i)
+		if err := dc.VerifyFlash(start, part); err != nil {
+			j.Sectors[i] = SectorPending
+			if err := j.Save(path); err != nil {
+				return err
+			}
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// erased returns size bytes of erased flash
+func erased(size int) []byte {
+	buf := make([]byte, size)
+	for i := range buf {
+		buf[i] = 0xFF
+	}
+	return buf
+}
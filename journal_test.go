@@ -0,0 +1,221 @@
+package ccboot_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+	"github.com/openchirp/ccboot/ccboottest"
+)
+
+func TestFlashWithJournalResume(t *testing.T) {
+	const sectorSize = 0x1000
+	path := filepath.Join(t.TempDir(), "flash.journal")
+	b := ccboottest.NewBootloader(ccboottest.DefaultConfig)
+	data := testImage(4*sectorSize - 0x10)
+
+	// interrupt the flash once the second sector is programmed
+	var erases, programs int
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := ccboot.NewDeviceWithOptions(b, ccboot.Options{
+		OnProgress: func(p ccboot.Progress) {
+			switch p.Phase {
+			case ccboot.PhaseErase:
+				erases++
+			case ccboot.PhaseProgram:
+				programs++
+				if programs == 2 {
+					cancel()
+				}
+			}
+		},
+	})
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+	err := d.WithContext(ctx).FlashWithJournal(path, 0x2000, data, sectorSize)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Interrupted flash returned %v, expected %v", err, context.Canceled)
+	}
+
+	j, err := ccboot.LoadJournal(path)
+	if err != nil {
+		t.Fatalf("Error loading journal: %v", err)
+	}
+	expected := []ccboot.SectorState{ccboot.SectorVerified, ccboot.SectorProgrammed, ccboot.SectorPending, ccboot.SectorPending}
+	for i, state := range expected {
+		if j.Sectors[i] != state {
+			t.Fatalf("Journal records sectors %v, expected %v", j.Sectors, expected)
+		}
+	}
+
+	// the rerun only erases the sectors that were never programmed
+	erases = 0
+	if err := d.FlashWithJournal(path, 0x2000, data, sectorSize); err != nil {
+		t.Fatalf("Error resuming flash: %v", err)
+	}
+	if erases != 2 {
+		t.Errorf("Resumed flash erased %d sectors, expected 2", erases)
+	}
+	if !bytes.Equal(b.Flash()[0x2000:0x2000+len(data)], data) {
+		t.Error("Flash contents do not match the image")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Journal was not removed after a complete flash: %v", err)
+	}
+}
+
+func TestFlashWithJournalStale(t *testing.T) {
+	const sectorSize = 0x1000
+	path := filepath.Join(t.TempDir(), "flash.journal")
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	data := testImage(2 * sectorSize)
+
+	// a journal that claims work the device does not hold
+	j := &ccboot.Journal{Sectors: []ccboot.SectorState{ccboot.SectorVerified, ccboot.SectorVerified}}
+	if err := j.Save(path); err != nil {
+		t.Fatalf("Error saving journal: %v", err)
+	}
+	if err := d.FlashWithJournal(path, 0x0, data, sectorSize); err != nil {
+		t.Fatalf("Error flashing: %v", err)
+	}
+	if !bytes.Equal(b.Flash()[:len(data)], data) {
+		t.Error("Flash contents do not match the image")
+	}
+}
+
+// resumeJournal interrupts a journaled flash of data at 0x2000 after its
+// first erase, rewrites the journal to record states, lets setup bring
+// the flash in line, and flashes again. It returns the addresses of the
+// sector erases and downloads of the second run.
+func resumeJournal(t *testing.T, data []byte, states []ccboot.SectorState, setup func(b *ccboottest.Bootloader)) (erases, downloads []uint32) {
+	t.Helper()
+	const sectorSize = 0x1000
+	path := filepath.Join(t.TempDir(), "flash.journal")
+	b := ccboottest.NewBootloader(ccboottest.DefaultConfig)
+
+	tracing := false
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := ccboot.NewDeviceWithOptions(b, ccboot.Options{
+		OnProgress: func(p ccboot.Progress) {
+			if p.Phase == ccboot.PhaseErase {
+				cancel()
+			}
+		},
+		Tracer: ccboot.TracerFunc(func(f ccboot.Frame) {
+			if !tracing || f.Dir != ccboot.FrameSent || f.Kind != ccboot.FramePacket {
+				return
+			}
+			switch ccboot.CommandType(f.Bytes[2]) {
+			case ccboot.COMMAND_SECTOR_ERASE:
+				erases = append(erases, binary.BigEndian.Uint32(f.Bytes[3:7]))
+			case ccboot.COMMAND_DOWNLOAD:
+				downloads = append(downloads, binary.BigEndian.Uint32(f.Bytes[3:7]))
+			}
+		}),
+	})
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+	if err := d.WithContext(ctx).FlashWithJournal(path, 0x2000, data, sectorSize); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Interrupted flash returned %v, expected %v", err, context.Canceled)
+	}
+
+	j, err := ccboot.LoadJournal(path)
+	if err != nil {
+		t.Fatalf("Error loading journal: %v", err)
+	}
+	j.Sectors = states
+	if err := j.Save(path); err != nil {
+		t.Fatalf("Error saving journal: %v", err)
+	}
+	setup(b)
+
+	tracing = true
+	if err := d.FlashWithJournal(path, 0x2000, data, sectorSize); err != nil {
+		t.Fatalf("Error resuming flash: %v", err)
+	}
+	if !bytes.Equal(b.Flash()[0x2000:0x2000+len(data)], data) {
+		t.Error("Flash contents do not match the image")
+	}
+	return erases, downloads
+}
+
+func TestFlashWithJournalResumeErased(t *testing.T) {
+	data := testImage(0x4000 - 0x10)
+	states := []ccboot.SectorState{ccboot.SectorVerified, ccboot.SectorVerified, ccboot.SectorErased, ccboot.SectorPending}
+	erases, downloads := resumeJournal(t, data, states, func(b *ccboottest.Bootloader) {
+		// the device holds what the journal records
+		b.LoadFlash(0x2000, data[:0x2000])
+	})
+
+	// the erased sector is programmed without erasing it again, and the
+	// verified ones are left alone
+	if len(erases) != 1 || erases[0] != 0x5000 {
+		t.Errorf("Resumed flash erased sectors at %X, expected only 0x5000", erases)
+	}
+	if len(downloads) == 0 || downloads[0] != 0x4000 {
+		t.Errorf("Resumed flash programmed from %X, expected to start at 0x4000", downloads)
+	}
+}
+
+func TestFlashWithJournalResumeNotErased(t *testing.T) {
+	data := testImage(0x4000 - 0x10)
+	states := []ccboot.SectorState{ccboot.SectorVerified, ccboot.SectorErased, ccboot.SectorPending, ccboot.SectorPending}
+	erases, downloads := resumeJournal(t, data, states, func(b *ccboottest.Bootloader) {
+		b.LoadFlash(0x2000, data[:0x1000])
+		// the sector recorded as erased was partly programmed since
+		b.LoadFlash(0x3000, data[0x1000:0x1010])
+	})
+
+	// the CRC of the erased sector does not match, so it is erased again
+	expected := []uint32{0x3000, 0x4000, 0x5000}
+	if len(erases) != len(expected) {
+		t.Fatalf("Resumed flash erased sectors at %X, expected %X", erases, expected)
+	}
+	for i, address := range expected {
+		if erases[i] != address {
+			t.Errorf("Resumed flash erased sectors at %X, expected %X", erases, expected)
+			break
+		}
+	}
+	if len(downloads) == 0 || downloads[0] != 0x3000 {
+		t.Errorf("Resumed flash programmed from %X, expected to start at 0x3000", downloads)
+	}
+}
+
+func TestFlashWithJournalSectorSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flash.journal")
+	d, b := newSimDevice(t, ccboottest.CC26x2Config)
+	data := testImage(0x2000)
+
+	// the CC26x2 erases 8 KB at a time
+	err := d.FlashWithJournal(path, 0x2000, data, 0x1000)
+	if !errors.Is(err, ccboot.ErrBadArguments) {
+		t.Fatalf("Flash with 4 KB sectors returned %v, expected %v", err, ccboot.ErrBadArguments)
+	}
+	if !bytes.Equal(b.Flash()[0x2000:0x4000], bytes.Repeat([]byte{0xFF}, 0x2000)) {
+		t.Error("Refused flash changed the device")
+	}
+
+	// a geometry that is wrong about the erase size is caught by the
+	// final verify, as each erase wipes the sector before it
+	opts := ccboot.DefaultOptions
+	opts.Geometry = ccboot.Geometry{FlashBase: 0, FlashSize: 0x58000, SectorSize: 0x1000}
+	d = ccboot.NewDeviceWithOptions(b, opts)
+	err = d.FlashWithJournal(path, 0x2000, data, 0x1000)
+	var verifyErr *ccboot.VerifyError
+	if !errors.As(err, &verifyErr) || verifyErr.Address < 0x2000 || verifyErr.Address >= 0x3000 {
+		t.Fatalf("Flash with a wrong geometry returned %v, expected a *VerifyError in 0x2000-0x2FFF", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Journal was removed after a failed verify: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package ccboot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrLockout is matched by errors for flash writes that were refused
+// because they would lock the device out
+var ErrLockout = errors.New("Programming would lock the device out")
+
+// LockoutError reports a CCFG that would disable the serial bootloader
+// or lock all debug access. It matches ErrLockout.
+type LockoutError struct {
+	// Address is the address of the CCFG block
+	Address uint32
+	// Fields lists the fields that lock the device out
+	Fields []CCFG_FieldID
+	CCFG   *CCFG
+}
+
+func (e *LockoutError) Error() string {
+	names := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		names[i] = f.String()
+	}
+	return fmt.Sprintf("CCFG at 0x%.8X would lock the device out: %s", e.Address, strings.Join(names, ", "))
+}
+
+func (e *LockoutError) Unwrap() error {
+	return ErrLockout
+}
+
+// LockoutFields returns the fields of c that disable the serial
+// bootloader or lock all debug access, in the order of their IDs
+func (c *CCFG) LockoutFields() []CCFG_FieldID {
+	var fields []CCFG_FieldID
+	if !c.CPUDAPEnable.Enabled() {
+		fields = append(fields, ID_CPU_DAP_LCK)
+	}
+	if !c.BackdoorEnable.Enabled() {
+		fields = append(fields, ID_BL_BACKDOOR_EN)
+	}
+	if !c.BootloaderEnable.Enabled() {
+		fields = append(fields, ID_BL_ENABLE)
+	}
+	return fields
+}
+
+// WithLockoutAllowed returns a shallow copy of d that programs a CCFG
+// even if it would lock the device out. The copy shares the port with d.
+func (d *Device) WithLockoutAllowed() *Device {
+	d2 := *d
+	d2.opts.AllowLockout = true
+	return &d2
+}
+
+// checkLockout refuses to program segments whose CCFG would lock the
+// device out, unless the device allows it.
+//
+// Bytes of the CCFG that the segments do not cover are taken to be
+// erased, which disables every feature, so a partial CCFG is refused.
+func (d *Device) checkLockout(segments ...Segment) error {
+	if d.opts.AllowLockout {
+		return nil
+	}
+	size := 0
+	for _, seg := range segments {
+		size += len(seg.Data)
+	}
+	if size == 0 {
+		return nil
+	}
+	address, ok, err := d.ccfgAddress()
+	if err != nil || !ok {
+		return err
+	}
+
+	block := erased(CCFGSize)
+	overlaps := false
+	for _, seg := range segments {
+		start := maxUint64(uint64(seg.Address), uint64(address))
+		end := minUint64(seg.End(), uint64(address)+CCFGSize)
+		if start >= end {
+			continue
+		}
+		copy(block[start-uint64(address):], seg.Data[start-uint64(seg.Address):end-uint64(seg.Address)])
+		overlaps = true
+	}
+	if !overlaps {
+		return nil
+	}
+	c, err := ParseCCFG(block)
+	if err != nil {
+		return err
+	}
+	if fields := c.LockoutFields(); len(fields) > 0 {
+		return &LockoutError{Address: address, Fields: fields, CCFG: c}
+	}
+	return nil
+}
+
+// ccfgAddress returns the address of the device's CCFG block. Once the
+// variant is known to have a CCFG, it is found from Options.Geometry,
+// or else from the flash size found by Probe or read from the
+// FLASH_SIZE register. It reports false if the device has no CCFG or
+// its flash size is not known.
+func (d *Device) ccfgAddress() (uint32, bool, error) {
+	v, err := d.variant()
+	if err != nil {
+		return 0, false, err
+	}
+	if !v.Supports(COMMAND_SET_CCFG) {
+		return 0, false, nil
+	}
+	if g := d.opts.Geometry; g.valid() {
+		return g.FlashBase + g.FlashSize - CCFGSize, true, nil
+	}
+	if !d.state.flashSizeKnown {
+		sectors, err := d.readWord(regFlashSize)
+		if err != nil {
+			return 0, false, err
+		}
+		d.state.flashSize = flashSize(sectors, v)
+		d.state.flashSizeKnown = true
+	}
+	if d.state.flashSize < CCFGSize {
+		return 0, false, nil
+	}
+	return v.ConfigArea(d.state.flashSize).Address, true, nil
+}
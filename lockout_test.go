@@ -0,0 +1,172 @@
+package ccboot_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+	"github.com/openchirp/ccboot/ccboottest"
+)
+
+// lockedCCFG returns unlockedCCFG with the bootloader and CPU DAP
+// disabled
+func lockedCCFG(t *testing.T) []byte {
+	t.Helper()
+	c, err := ccboot.ParseCCFG(unlockedCCFG())
+	if err != nil {
+		t.Fatalf("Error parsing CCFG: %v", err)
+	}
+	c.BootloaderEnable = ccboot.CCFGDisabled
+	c.CPUDAPEnable = ccboot.CCFGDisabled
+	return c.Marshal()
+}
+
+func checkLockout(t *testing.T, err error, address uint32, fields ...ccboot.CCFG_FieldID) {
+	t.Helper()
+	var lockout *ccboot.LockoutError
+	if !errors.As(err, &lockout) || !errors.Is(err, ccboot.ErrLockout) {
+		t.Fatalf("Write returned %v, expected a *LockoutError", err)
+	}
+	if lockout.Address != address {
+		t.Errorf("Lockout reported at 0x%X, expected 0x%X", lockout.Address, address)
+	}
+	if !reflect.DeepEqual(lockout.Fields, fields) {
+		t.Errorf("Lockout fields are %v, expected %v", lockout.Fields, fields)
+	}
+}
+
+func TestLockoutWriteImage(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	img := ccboot.NewImage(0x1000, testImage(0x100))
+	if err := img.Add(0x1FFA8, lockedCCFG(t)); err != nil {
+		t.Fatalf("Error adding CCFG: %v", err)
+	}
+
+	err := d.WriteImage(img)
+	checkLockout(t, err, 0x1FFA8, ccboot.ID_CPU_DAP_LCK, ccboot.ID_BL_ENABLE)
+	if flash := b.Flash(); !bytes.Equal(flash[0x1000:0x1100], bytes.Repeat([]byte{0xFF}, 0x100)) {
+		t.Error("Refused image was partly programmed")
+	}
+	if err.Error() != "CCFG at 0x0001FFA8 would lock the device out: ID_CPU_DAP_LCK, ID_BL_ENABLE" {
+		t.Errorf("Lockout error is %q", err)
+	}
+
+	if err := d.WithLockoutAllowed().WriteImage(img); err != nil {
+		t.Fatalf("Error writing image with lockout allowed: %v", err)
+	}
+	if err := d.VerifyImage(img); err != nil {
+		t.Errorf("Error verifying image: %v", err)
+	}
+}
+
+func TestLockoutPartialCCFG(t *testing.T) {
+	d, _ := newSimDevice(t, ccboottest.DefaultConfig)
+	// only BL_CONFIG, enabling the bootloader and backdoor, is written,
+	// so the erased CPU DAP field is disabled
+	err := d.WriteFlash(0x1FFD8, unlockedCCFG()[0x30:0x34])
+	checkLockout(t, err, 0x1FFA8, ccboot.ID_CPU_DAP_LCK)
+
+	// data outside of the CCFG is not checked
+	if err := d.WriteFlash(0x1FF00, testImage(0xA8)); err != nil {
+		t.Errorf("Error writing below the CCFG: %v", err)
+	}
+}
+
+func TestLockoutUpdateAndJournal(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	b.LoadFlash(0x1F000, testImage(0x100))
+	data := make([]byte, 0x1000)
+	copy(data[0x1000-ccboot.CCFGSize:], lockedCCFG(t))
+
+	_, err := d.UpdateFlash(0x1F000, data, 0x1000)
+	checkLockout(t, err, 0x1FFA8, ccboot.ID_CPU_DAP_LCK, ccboot.ID_BL_ENABLE)
+	path := filepath.Join(t.TempDir(), "journal")
+	err = d.FlashWithJournal(path, 0x1F000, data, 0x1000)
+	checkLockout(t, err, 0x1FFA8, ccboot.ID_CPU_DAP_LCK, ccboot.ID_BL_ENABLE)
+
+	if !bytes.Equal(b.Flash()[0x1F000:0x1F100], testImage(0x100)) {
+		t.Error("Refused write erased the sector")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Refused write left a journal: %v", err)
+	}
+}
+
+func TestLockoutDetectsGeometry(t *testing.T) {
+	// the CC26x2 CCFG is found from its FLASH_SIZE register
+	d, _ := newSimDevice(t, ccboottest.CC26x2Config)
+	err := d.WriteFlash(0x57FA8, lockedCCFG(t))
+	checkLockout(t, err, 0x57FA8, ccboot.ID_CPU_DAP_LCK, ccboot.ID_BL_ENABLE)
+
+	// an explicit geometry is used as is
+	opts := ccboot.DefaultOptions
+	opts.Geometry = ccboot.Geometry{FlashBase: 0, FlashSize: 0x10000, SectorSize: 0x1000}
+	d = ccboot.NewDeviceWithOptions(ccboottest.NewBootloader(ccboottest.DefaultConfig), opts)
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+	err = d.WriteFlash(0xFFA8, lockedCCFG(t))
+	checkLockout(t, err, 0xFFA8, ccboot.ID_CPU_DAP_LCK, ccboot.ID_BL_ENABLE)
+}
+
+func TestLockoutUpdateLastSector(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.DefaultConfig)
+	// the device's own CCFG has the backdoor disabled, which an update
+	// that does not touch the CCFG must leave alone
+	c, err := ccboot.ParseCCFG(unlockedCCFG())
+	if err != nil {
+		t.Fatalf("Error parsing CCFG: %v", err)
+	}
+	c.BackdoorEnable = ccboot.CCFGDisabled
+	ccfg := c.Marshal()
+	b.LoadFlash(0x1FFA8, ccfg)
+	b.LoadFlash(0x1F000, testImage(0x100))
+
+	data := testImage(0x100)
+	for i := range data {
+		data[i] = ^data[i]
+	}
+	result, err := d.UpdateFlash(0x1F000, data, 0x1000)
+	if err != nil {
+		t.Fatalf("Error updating the last sector: %v", err)
+	}
+	if len(result.Rewritten) != 1 || result.Rewritten[0] != 0x1F000 {
+		t.Errorf("Rewrote sectors %v, expected 0x1F000", result.Rewritten)
+	}
+	flash := b.Flash()
+	if !bytes.Equal(flash[0x1F000:0x1F100], data) {
+		t.Error("Flash does not hold the update")
+	}
+	if !bytes.Equal(flash[0x1FFA8:], ccfg) {
+		t.Errorf("CCFG is %x, expected %x", flash[0x1FFA8:], ccfg)
+	}
+}
+
+func TestLockoutCC2538Geometry(t *testing.T) {
+	// the CC2538 has no CCFG, so the lock bit page at the end of its flash
+	// is not checked, whether or not the variant is given
+	geometry := ccboot.Geometry{FlashBase: 0x200000, FlashSize: 0x80000, SectorSize: 0x800}
+	cca := append(le32(0xF3FFFFFF), le32(0)...)
+	cca = append(cca, le32(0x200000)...)
+	cca = append(cca, bytes.Repeat([]byte{0xFF}, 0x20)...)
+	for _, variant := range []*ccboot.Variant{ccboot.VariantCC2538, nil} {
+		opts := ccboot.DefaultOptions
+		opts.Geometry = geometry
+		opts.Variant = variant
+		b := ccboottest.NewBootloader(ccboottest.CC2538Config)
+		d := ccboot.NewDeviceWithOptions(b, opts)
+		if err := d.Sync(); err != nil {
+			t.Fatalf("Error syncing to simulator: %v", err)
+		}
+		if err := d.WriteFlash(0x27FFD4, cca); err != nil {
+			t.Fatalf("Error writing the lock bit page with variant %v: %v", variant, err)
+		}
+		if flash := b.Flash(); !bytes.Equal(flash[0x7FFD4:], cca) {
+			t.Errorf("Lock bit page is %x, expected %x", flash[0x7FFD4:], cca)
+		}
+	}
+}
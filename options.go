@@ -0,0 +1,176 @@
+package ccboot
+
+import (
+	"time"
+)
+
+// Options tunes how a Device retries and waits for the bootloader.
+//
+// Zero fields take their value from DefaultOptions.
+type Options struct {
+	// SyncAttempts is the number of times Sync sends the sync word
+	SyncAttempts int
+	// SendAttempts is the number of times a packet is sent before giving
+	// up on NACKs, garbage or a missing ACK
+	SendAttempts int
+	// RecvAttempts is the number of times a malformed response packet is
+	// NACKed and received again
+	RecvAttempts int
+	// ReadAttempts is the number of empty port reads tolerated while
+	// waiting for a byte, when no response timeout applies
+	ReadAttempts int
+
+	// ResponseTimeout is how long to keep reading while waiting for a byte
+	// from the device. When it is zero, ReadAttempts empty reads are
+	// allowed instead, so the wait depends on the port's read timeout.
+	ResponseTimeout time.Duration
+	// CommandTimeouts overrides ResponseTimeout for the ACK and response
+	// of specific commands. Its entries are added to those of
+	// DefaultOptions, replacing the defaults for the same commands.
+	CommandTimeouts map[CommandType]time.Duration
+
+	// Backoff is the delay before the sync word or a packet is sent again.
+	// Each following retry doubles the delay, up to MaxBackoff if it is set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// CheckStatus makes Download, SendData, SectorErase, BankErase,
+	// MemoryWrite and SetCCFG follow the command with GetStatus. A status
+	// other than COMMAND_RET_SUCCESS is returned as a *CommandError.
+	CheckStatus bool
+
+	// OnProgress, if set, is called as WriteFlash, UpdateFlash,
+	// VerifyFlash and ReadMemory make progress
+	OnProgress func(Progress)
+
+	// Tracer, if set, receives every frame sent to or received from
+	// the device
+	Tracer Tracer
+
+	// Variant, if set, is the bootloader variant the device speaks.
+	// Otherwise it is selected by the chip ID that GetChipID reads.
+	Variant *Variant
+
+	// Geometry, if set, is the flash layout of the device, which locates
+	// its CCFG. Otherwise the flash size is read from the device.
+	Geometry Geometry
+	// AllowLockout lets WriteFlash, WriteImage, UpdateFlash and
+	// FlashWithJournal program a CCFG that disables the serial
+	// bootloader or locks all debug access. Such writes otherwise fail
+	// with a *LockoutError before anything is erased or programmed.
+	AllowLockout bool
+}
+
+// DefaultOptions are the options used by NewDevice
+var DefaultOptions = Options{
+	SyncAttempts: 3,
+	SendAttempts: 3,
+	RecvAttempts: 3,
+	ReadAttempts: 3,
+	CommandTimeouts: map[CommandType]time.Duration{
+		COMMAND_BANK_ERASE:   5 * time.Second,
+		COMMAND_SECTOR_ERASE: 500 * time.Millisecond,
+		COMMAND_CRC32:        2 * time.Second,
+	},
+}
+
+// withDefaults fills in the zero fields of o from DefaultOptions
+func (o Options) withDefaults() Options {
+	if o.SyncAttempts == 0 {
+		o.SyncAttempts = DefaultOptions.SyncAttempts
+	}
+	if o.SendAttempts == 0 {
+		o.SendAttempts = DefaultOptions.SendAttempts
+	}
+	if o.RecvAttempts == 0 {
+		o.RecvAttempts = DefaultOptions.RecvAttempts
+	}
+	if o.ReadAttempts == 0 {
+		o.ReadAttempts = DefaultOptions.ReadAttempts
+	}
+	if o.ResponseTimeout == 0 {
+		o.ResponseTimeout = DefaultOptions.ResponseTimeout
+	}
+	timeouts := make(map[CommandType]time.Duration, len(DefaultOptions.CommandTimeouts)+len(o.CommandTimeouts))
+	for cmd, timeout := range DefaultOptions.CommandTimeouts {
+		timeouts[cmd] = timeout
+	}
+	for cmd, timeout := range o.CommandTimeouts {
+		timeouts[cmd] = timeout
+	}
+	o.CommandTimeouts = timeouts
+	if o.Backoff == 0 {
+		o.Backoff = DefaultOptions.Backoff
+	}
+	if o.MaxBackoff == 0 {
+		o.MaxBackoff = DefaultOptions.MaxBackoff
+	}
+	return o
+}
+
+// responseTimeout returns the time to wait for a byte in response to cmd
+func (o *Options) responseTimeout(cmd CommandType) time.Duration {
+	if timeout, ok := o.CommandTimeouts[cmd]; ok {
+		return timeout
+	}
+	return o.ResponseTimeout
+}
+
+// backoffDelay returns the delay before the given retry, counting from 1
+func (o *Options) backoffDelay(retry int) time.Duration {
+	delay := o.Backoff
+	for i := 1; i < retry && delay > 0; i++ {
+		delay *= 2
+		if o.MaxBackoff > 0 && delay >= o.MaxBackoff {
+			break
+		}
+	}
+	if o.MaxBackoff > 0 && delay > o.MaxBackoff {
+		delay = o.MaxBackoff
+	}
+	return delay
+}
+
+// responseWait decides how long to keep reading while waiting for a byte
+type responseWait struct {
+	deadline  time.Time
+	misses    int
+	maxMisses int
+}
+
+func (d *Device) newResponseWait() responseWait {
+	if timeout := d.opts.responseTimeout(d.state.lastCmd); timeout > 0 {
+		return responseWait{deadline: time.Now().Add(timeout)}
+	}
+	return responseWait{maxMisses: d.opts.ReadAttempts}
+}
+
+// miss records an empty read
+func (w *responseWait) miss() {
+	w.misses++
+}
+
+// expired reports whether the device has run out of time to respond
+func (w *responseWait) expired() bool {
+	if !w.deadline.IsZero() {
+		return w.misses > 0 && time.Now().After(w.deadline)
+	}
+	return w.misses > w.maxMisses
+}
+
+// backoff sleeps before the given retry, returning early with an error
+// if the device's context is done
+func (d *Device) backoff(retry int) error {
+	delay := d.opts.backoffDelay(retry)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-d.ctx.Done():
+		return d.checkContext()
+	case <-timer.C:
+		return nil
+	}
+}
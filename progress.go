@@ -0,0 +1,83 @@
+package ccboot
+
+import (
+	"fmt"
+	"time"
+)
+
+// Phase is the stage of a long running operation
+type Phase byte
+
+const (
+	PhaseErase   = Phase(0)
+	PhaseProgram = Phase(1)
+	PhaseVerify  = Phase(2)
+	PhaseRead    = Phase(3)
+)
+
+var phase2String = map[Phase]string{
+	PhaseErase:   "erase",
+	PhaseProgram: "program",
+	PhaseVerify:  "verify",
+	PhaseRead:    "read",
+}
+
+func (p Phase) String() string {
+	if str, ok := phase2String[p]; ok {
+		return str
+	}
+	return fmt.Sprintf("0x%X", byte(p))
+}
+
+// Progress describes how far WriteFlash, UpdateFlash, VerifyFlash or
+// ReadMemory has come
+type Progress struct {
+	Phase Phase
+	// Done and Total are counted in bytes of the caller's data
+	Done  uint32
+	Total uint32
+	// Address is where the operation is working now
+	Address uint32
+	// Rate is the average throughput so far, in bytes per second
+	Rate float64
+}
+
+// progress tracks an operation and reports it to Options.OnProgress
+type progress struct {
+	fn    func(Progress)
+	start time.Time
+	done  uint32
+	total uint32
+}
+
+// startProgress begins tracking an operation on total bytes. It returns
+// nil if the device has no progress callback.
+func (d *Device) startProgress(total int) *progress {
+	if d.opts.OnProgress == nil {
+		return nil
+	}
+	return &progress{fn: d.opts.OnProgress, start: time.Now(), total: uint32(total)}
+}
+
+// report adds n bytes to the work done and reports it. It does nothing
+// on a nil progress.
+func (p *progress) report(phase Phase, address uint32, n int) {
+	if p == nil {
+		return
+	}
+	p.done += uint32(n)
+	if p.done > p.total {
+		p.done = p.total
+	}
+	rate := 0.0
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		rate = float64(p.done) / elapsed
+	}
+	p.fn(Progress{
+		Phase:   phase,
+		Done:    p.done,
+		Total:   p.total,
+		Address: address,
+		Rate:    rate,
+	})
+}
@@ -0,0 +1,128 @@
+package ccboot
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// srecLineSize is the number of data bytes WriteSRecord puts on a line
+const srecLineSize = 32
+
+// srecAddrSize gives the address size of each S-record type
+var srecAddrSize = map[byte]int{
+	'0': 2, '1': 2, '2': 3, '3': 4, '5': 2, '6': 3, '7': 4, '8': 3, '9': 2,
+}
+
+// ReadSRecord parses a Motorola S-record file with S19, S28 or S37
+// records. A record count (S5/S6) must match the number of data records
+// before it. The file must end with a termination record (S7/S8/S9),
+// which sets the image's entry point.
+func ReadSRecord(r io.Reader) (*Image, error) {
+	img := new(Image)
+	records := 0
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			continue
+		}
+		if len(text) < 2 || text[0] != 'S' {
+			return nil, &ParseError{line, "record does not start with 'S'"}
+		}
+		typ := text[1]
+		addrSize, ok := srecAddrSize[typ]
+		if !ok {
+			return nil, &ParseError{line, fmt.Sprintf("unknown record type S%c", typ)}
+		}
+		rec, err := hex.DecodeString(text[2:])
+		if err != nil {
+			return nil, &ParseError{line, "invalid hex digits"}
+		}
+		if len(rec) < 1+addrSize+1 || len(rec) != 1+int(rec[0]) {
+			return nil, &ParseError{line, "record length does not match its byte count"}
+		}
+		if sum := checksum(rec); sum != 0xFF {
+			return nil, &ParseError{line, fmt.Sprintf("bad checksum 0x%.2X", rec[len(rec)-1])}
+		}
+		address := decodeUint32(rec[1 : 1+addrSize])
+		data := rec[1+addrSize : len(rec)-1]
+
+		switch typ {
+		case '0':
+			// header
+		case '1', '2', '3':
+			if err := img.Add(address, data); err != nil {
+				return nil, &ParseError{line, err.Error()}
+			}
+			records++
+		case '5', '6':
+			if int(address) != records {
+				return nil, &ParseError{line, fmt.Sprintf("record count is %d, but %d data records were read", address, records)}
+			}
+		case '7', '8', '9':
+			img.Entry, img.HasEntry = address, true
+			return img, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, &ParseError{line, "missing termination record"}
+}
+
+// WriteSRecord writes img as a Motorola S-record file. It uses the
+// smallest of S19, S28 or S37 that can hold every address.
+func WriteSRecord(w io.Writer, img *Image) error {
+	last := uint64(0)
+	if n := len(img.Segments); n > 0 {
+		last = img.Segments[n-1].End() - 1
+	}
+	if img.HasEntry && uint64(img.Entry) > last {
+		last = uint64(img.Entry)
+	}
+	data, term := byte('1'), byte('9')
+	switch {
+	case last > 0xFFFFFF:
+		data, term = '3', '7'
+	case last > 0xFFFF:
+		data, term = '2', '8'
+	}
+
+	bw := bufio.NewWriter(w)
+	writeSRecord(bw, '0', 0, []byte("ccboot"))
+	records := 0
+	for _, seg := range img.Segments {
+		for off := 0; off < len(seg.Data); off += srecLineSize {
+			end := off + srecLineSize
+			if end > len(seg.Data) {
+				end = len(seg.Data)
+			}
+			writeSRecord(bw, data, seg.Address+uint32(off), seg.Data[off:end])
+			records++
+		}
+	}
+	if records <= 0xFFFF {
+		writeSRecord(bw, '5', uint32(records), nil)
+	} else {
+		writeSRecord(bw, '6', uint32(records), nil)
+	}
+	writeSRecord(bw, term, img.Entry, nil)
+	return bw.Flush()
+}
+
+func writeSRecord(w *bufio.Writer, typ byte, address uint32, data []byte) {
+	addrSize := srecAddrSize[typ]
+	rec := make([]byte, 0, 2+addrSize+len(data))
+	rec = append(rec, byte(addrSize+len(data)+1))
+	for i := addrSize - 1; i >= 0; i-- {
+		rec = append(rec, byte(address>>(uint(i)*8)))
+	}
+	rec = append(rec, data...)
+	rec = append(rec, ^checksum(rec))
+	fmt.Fprintf(w, "S%c%s\n", typ, strings.ToUpper(hex.EncodeToString(rec)))
+}
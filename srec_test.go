@@ -0,0 +1,82 @@
+package ccboot_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+)
+
+const sampleSRecord = `S00600004844521B
+S20801000001020304EC
+S2060100040506E9
+S5030002FA
+S804010001F9
+`
+
+func TestReadSRecord(t *testing.T) {
+	img, err := ccboot.ReadSRecord(strings.NewReader(sampleSRecord))
+	if err != nil {
+		t.Fatalf("Error parsing S-record: %v", err)
+	}
+	if len(img.Segments) != 1 || img.Segments[0].Address != 0x10000 || !bytes.Equal(img.Segments[0].Data, []byte{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("Image segments are %+v", img.Segments)
+	}
+	if !img.HasEntry || img.Entry != 0x10001 {
+		t.Errorf("Entry is 0x%X (%v), expected 0x10001", img.Entry, img.HasEntry)
+	}
+}
+
+func TestReadSRecordErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		line int
+	}{
+		{"S00600004844521B\nS20801000001020304ED\n", 2},
+		{"S00600004844521B\n\nS5030003F9\n", 3},
+		{"S4030002FA\n", 1},
+		{"S2080100000102030\n", 1},
+		// truncated before the termination record
+		{"S00600004844521B\nS20801000001020304EC\n", 2},
+	}
+	for _, tt := range tests {
+		_, err := ccboot.ReadSRecord(strings.NewReader(tt.text))
+		var parseErr *ccboot.ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("Parsing %q returned %v, expected a *ParseError", tt.text, err)
+			continue
+		}
+		if parseErr.Line != tt.line {
+			t.Errorf("Parsing %q failed on line %d, expected %d: %v", tt.text, parseErr.Line, tt.line, err)
+		}
+	}
+}
+
+func TestWriteSRecord(t *testing.T) {
+	for _, tt := range []struct {
+		address uint32
+		prefix  string
+	}{
+		{0x1000, "S1"},
+		{0x1FFA8, "S2"},
+		{0x20000000, "S3"},
+	} {
+		img := ccboot.NewImage(tt.address, testImage(100))
+		var buf bytes.Buffer
+		if err := ccboot.WriteSRecord(&buf, img); err != nil {
+			t.Fatalf("Error writing S-record: %v", err)
+		}
+		if lines := strings.Split(buf.String(), "\n"); !strings.HasPrefix(lines[1], tt.prefix) {
+			t.Errorf("Data at 0x%X was written as %q, expected %s records", tt.address, lines[1], tt.prefix)
+		}
+		got, err := ccboot.ReadSRecord(&buf)
+		if err != nil {
+			t.Fatalf("Error parsing written S-record: %v", err)
+		}
+		if len(got.Segments) != 1 || got.Segments[0].Address != tt.address || !bytes.Equal(got.Segments[0].Data, testImage(100)) {
+			t.Errorf("Read back segments %+v", got.Segments)
+		}
+	}
+}
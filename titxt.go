@@ -0,0 +1,87 @@
+package ccboot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// titxtLineSize is the number of data bytes WriteTITxt puts on a line
+const titxtLineSize = 16
+
+// ReadTITxt parses a TI-TXT file, as produced for the MSP430 and by
+// TI's hex conversion utility. Each "@ADDR" line starts a section, the
+// following lines hold space separated hex bytes and "q" ends the file.
+func ReadTITxt(r io.Reader) (*Image, error) {
+	img := new(Image)
+	address := uint64(0)
+	inSection := false
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(text) == 0:
+			continue
+		case text == "q" || text == "Q":
+			return img, nil
+		case text[0] == '@':
+			v, err := strconv.ParseUint(text[1:], 16, 32)
+			if err != nil {
+				return nil, &ParseError{line, fmt.Sprintf("invalid section address %q", text[1:])}
+			}
+			address = v
+			inSection = true
+		default:
+			if !inSection {
+				return nil, &ParseError{line, "data before the first section address"}
+			}
+			fields := strings.Fields(text)
+			data := make([]byte, len(fields))
+			for i, f := range fields {
+				v, err := strconv.ParseUint(f, 16, 8)
+				if err != nil || len(f) != 2 {
+					return nil, &ParseError{line, fmt.Sprintf("invalid byte %q", f)}
+				}
+				data[i] = byte(v)
+			}
+			if address+uint64(len(data)) > 1<<32 {
+				return nil, &ParseError{line, "data extends past the 32 bit address space"}
+			}
+			if err := img.Add(uint32(address), data); err != nil {
+				return nil, &ParseError{line, err.Error()}
+			}
+			address += uint64(len(data))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, &ParseError{line, "missing 'q' at end of file"}
+}
+
+// WriteTITxt writes img as a TI-TXT file
+func WriteTITxt(w io.Writer, img *Image) error {
+	bw := bufio.NewWriter(w)
+	for _, seg := range img.Segments {
+		fmt.Fprintf(bw, "@%04X\n", seg.Address)
+		for off := 0; off < len(seg.Data); off += titxtLineSize {
+			end := off + titxtLineSize
+			if end > len(seg.Data) {
+				end = len(seg.Data)
+			}
+			for i, v := range seg.Data[off:end] {
+				if i > 0 {
+					bw.WriteByte(' ')
+				}
+				fmt.Fprintf(bw, "%02X", v)
+			}
+			bw.WriteByte('\n')
+		}
+	}
+	bw.WriteString("q\n")
+	return bw.Flush()
+}
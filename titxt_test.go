@@ -0,0 +1,69 @@
+package ccboot_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+)
+
+func TestReadTITxt(t *testing.T) {
+	img, err := ccboot.ReadTITxt(strings.NewReader("@1000\n01 02 03\n04\n@1FFA8\nAA BB\nq\n"))
+	if err != nil {
+		t.Fatalf("Error parsing TI-TXT: %v", err)
+	}
+	if len(img.Segments) != 2 {
+		t.Fatalf("Image has segments %+v, expected 2", img.Segments)
+	}
+	if seg := img.Segments[0]; seg.Address != 0x1000 || !bytes.Equal(seg.Data, []byte{1, 2, 3, 4}) {
+		t.Errorf("First segment is %+v", seg)
+	}
+	if seg := img.Segments[1]; seg.Address != 0x1FFA8 || !bytes.Equal(seg.Data, []byte{0xAA, 0xBB}) {
+		t.Errorf("Second segment is %+v", seg)
+	}
+}
+
+func TestReadTITxtErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		line int
+	}{
+		{"01 02\nq\n", 1},
+		{"@1000\n01 2\nq\n", 2},
+		{"@10G0\n", 1},
+		{"@1000\n01 02\n", 2},
+		{"@1000\n01 02\n@1001\n03\nq\n", 4},
+	}
+	for _, tt := range tests {
+		_, err := ccboot.ReadTITxt(strings.NewReader(tt.text))
+		var parseErr *ccboot.ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("Parsing %q returned %v, expected a *ParseError", tt.text, err)
+			continue
+		}
+		if parseErr.Line != tt.line {
+			t.Errorf("Parsing %q failed on line %d, expected %d: %v", tt.text, parseErr.Line, tt.line, err)
+		}
+	}
+}
+
+func TestWriteTITxt(t *testing.T) {
+	img := ccboot.NewImage(0x0, testImage(40))
+	img.Add(0x1FFA8, []byte{0xFF, 0x00})
+	var buf bytes.Buffer
+	if err := ccboot.WriteTITxt(&buf, img); err != nil {
+		t.Fatalf("Error writing TI-TXT: %v", err)
+	}
+	if !strings.HasSuffix(buf.String(), "@1FFA8\nFF 00\nq\n") {
+		t.Errorf("TI-TXT output ends with %q", buf.String()[buf.Len()-20:])
+	}
+	got, err := ccboot.ReadTITxt(&buf)
+	if err != nil {
+		t.Fatalf("Error parsing written TI-TXT: %v", err)
+	}
+	if len(got.Segments) != 2 || !bytes.Equal(got.Segments[0].Data, testImage(40)) {
+		t.Errorf("Read back segments %+v", got.Segments)
+	}
+}
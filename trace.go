@@ -0,0 +1,199 @@
+package ccboot
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// FrameDir is the direction a frame traveled on the wire
+type FrameDir byte
+
+const (
+	FrameSent     = FrameDir(0)
+	FrameReceived = FrameDir(1)
+)
+
+func (dir FrameDir) String() string {
+	if dir == FrameSent {
+		return "tx"
+	}
+	return "rx"
+}
+
+// FrameKind tells how the bytes of a frame were interpreted
+type FrameKind byte
+
+const (
+	// FrameSync is the sync word or the device's reply to it
+	FrameSync = FrameKind(0)
+	// FrameAck is a single CC_ACK byte
+	FrameAck = FrameKind(1)
+	// FrameNack is a single CC_NACK byte
+	FrameNack = FrameKind(2)
+	// FramePacket is a sized and checksummed packet
+	FramePacket = FrameKind(3)
+	// FrameGarbage is a byte received where an ACK or NACK was expected
+	FrameGarbage = FrameKind(4)
+	// FrameDiscarded is bytes left over from an abandoned exchange, which
+	// were read and thrown away
+	FrameDiscarded = FrameKind(5)
+)
+
+var frameKind2String = map[FrameKind]string{
+	FrameSync:      "sync",
+	FrameAck:       "ack",
+	FrameNack:      "nack",
+	FramePacket:    "packet",
+	FrameGarbage:   "garbage",
+	FrameDiscarded: "discard",
+}
+
+func (k FrameKind) String() string {
+	if str, ok := frameKind2String[k]; ok {
+		return str
+	}
+	return fmt.Sprintf("0x%X", byte(k))
+}
+
+// Frame is a unit of bytes sent to or received from the bootloader
+type Frame struct {
+	Time time.Time
+	Dir  FrameDir
+	Kind FrameKind
+	// Command is the most recently sent command, which a received packet
+	// is a response to
+	Command CommandType
+	Bytes   []byte
+}
+
+// String annotates the frame with its meaning in the protocol
+func (f Frame) String() string {
+	switch f.Kind {
+	case FrameSync:
+		if f.Dir == FrameReceived {
+			return "SYNC response"
+		}
+		return "SYNC"
+	case FrameAck:
+		return "ACK"
+	case FrameNack:
+		return "NACK"
+	case FrameGarbage:
+		return "unexpected byte"
+	case FrameDiscarded:
+		return "discarded stale bytes"
+	case FramePacket:
+		if f.Dir == FrameSent {
+			cmd, err := decodeCmdPacket(f.Bytes)
+			if err != nil {
+				return "malformed packet"
+			}
+			return cmd.String()
+		}
+		data, err := decodePacket(f.Bytes)
+		if err != nil {
+			return "malformed packet"
+		}
+		if f.Command == COMMAND_GET_STATUS && len(data) == 1 {
+			return fmt.Sprintf("%v response (status=%v)", f.Command, Status(data[0]))
+		}
+		return fmt.Sprintf("%v response [%d]=(%s)", f.Command, len(data), hex.EncodeToString(data))
+	default:
+		return f.Kind.String()
+	}
+}
+
+// Tracer receives every frame that a Device sends or receives
+type Tracer interface {
+	Trace(f Frame)
+}
+
+// TracerFunc adapts an ordinary function to a Tracer
+type TracerFunc func(f Frame)
+
+func (fn TracerFunc) Trace(f Frame) {
+	fn(f)
+}
+
+// trace hands a copy of buf to the device's tracer, if it has one
+func (d *Device) trace(dir FrameDir, kind FrameKind, buf []byte) {
+	if d.opts.Tracer == nil {
+		return
+	}
+	d.opts.Tracer.Trace(Frame{
+		Time:    time.Now(),
+		Dir:     dir,
+		Kind:    kind,
+		Command: d.state.lastCmd,
+		Bytes:   append([]byte(nil), buf...),
+	})
+}
+
+// traceAck traces a byte that was sent or received as an acknowledgment
+func (d *Device) traceAck(dir FrameDir, ack byte) {
+	kind := FrameGarbage
+	switch ack {
+	case CC_ACK:
+		kind = FrameAck
+	case CC_NACK:
+		kind = FrameNack
+	}
+	d.trace(dir, kind, []byte{ack})
+}
+
+// TextTracer writes one human readable line per frame
+type TextTracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTextTracer creates a TextTracer that writes to w
+func NewTextTracer(w io.Writer) *TextTracer {
+	return &TextTracer{w: w}
+}
+
+func (t *TextTracer) Trace(f Frame) {
+	arrow := "->"
+	if f.Dir == FrameReceived {
+		arrow = "<-"
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "%s %s %-7v %s  %v\n", f.Time.Format("15:04:05.000000"), arrow, f.Kind, hex.EncodeToString(f.Bytes), f)
+}
+
+// JSONTracer writes one JSON object per frame, as JSON lines
+type JSONTracer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONTracer creates a JSONTracer that writes to w
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{enc: json.NewEncoder(w)}
+}
+
+// jsonFrame is the JSON form of a Frame
+type jsonFrame struct {
+	Time  time.Time `json:"time"`
+	Dir   string    `json:"dir"`
+	Kind  string    `json:"kind"`
+	Bytes string    `json:"bytes"`
+	Info  string    `json:"info"`
+}
+
+func (t *JSONTracer) Trace(f Frame) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.enc.Encode(jsonFrame{
+		Time:  f.Time,
+		Dir:   f.Dir.String(),
+		Kind:  f.Kind.String(),
+		Bytes: hex.EncodeToString(f.Bytes),
+		Info:  f.String(),
+	})
+}
@@ -34,6 +34,8 @@ const (
 	COMMAND_MEMORY_WRITE = CommandType(0x2B)
 	COMMAND_BANK_ERASE   = CommandType(0x2C)
 	COMMAND_SET_CCFG     = CommandType(0x2D)
+	// COMMAND_DOWNLOAD_CRC is only supported by CC13x2/CC26x2 bootloaders
+	COMMAND_DOWNLOAD_CRC = CommandType(0x2F)
 )
 
 var cmd2String = map[CommandType]string{
@@ -49,6 +51,7 @@ var cmd2String = map[CommandType]string{
 	COMMAND_MEMORY_WRITE: "COMMAND_MEMORY_WRITE",
 	COMMAND_BANK_ERASE:   "COMMAND_BANK_ERASE",
 	COMMAND_SET_CCFG:     "COMMAND_SET_CCFG",
+	COMMAND_DOWNLOAD_CRC: "COMMAND_DOWNLOAD_CRC",
 }
 
 func (c CommandType) String() string {
@@ -58,6 +61,17 @@ func (c CommandType) String() string {
 	return fmt.Sprintf("0x%X", byte(c))
 }
 
+// ParseCommandType parses a string that names a command, such as
+// "COMMAND_PING", and returns the proper CommandType value
+func ParseCommandType(name string) (CommandType, error) {
+	for value, str := range cmd2String {
+		if str == name {
+			return value, nil
+		}
+	}
+	return CommandType(0), ErrParse
+}
+
 // Command represents the command type and paramerters
 type Command struct {
 	Type       CommandType
@@ -95,21 +109,52 @@ func (c Command) String() string {
 		return c.Type.String()
 	case COMMAND_SECTOR_ERASE:
 		// address
-		return fmt.Sprintf("%v (addr=0x%s)", c.Type, hex.EncodeToString(c.Parameters[0:4]))
+		if len(c.Parameters) == 4 {
+			return fmt.Sprintf("%v (addr=0x%s)", c.Type, hex.EncodeToString(c.Parameters[0:4]))
+		}
+		// the CC2538 erase takes a size
+		if len(c.Parameters) == 8 {
+			return fmt.Sprintf("%v (addr=0x%s, size=%d)", c.Type, hex.EncodeToString(c.Parameters[0:4]), decodeUint32(c.Parameters[4:8]))
+		}
 	case COMMAND_CRC32:
 		//address, size, and read count
-		return fmt.Sprintf("%v (addr=0x%s, size=%d, read_count=%d)", c.Type, hex.EncodeToString(c.Parameters[0:4]), decodeUint32(c.Parameters[4:8]), decodeUint32(c.Parameters[8:]))
+		if len(c.Parameters) == 8 {
+			return fmt.Sprintf("%v (addr=0x%s, size=%d)", c.Type, hex.EncodeToString(c.Parameters[0:4]), decodeUint32(c.Parameters[4:8]))
+		}
+		if len(c.Parameters) > 8 {
+			return fmt.Sprintf("%v (addr=0x%s, size=%d, read_count=%d)", c.Type, hex.EncodeToString(c.Parameters[0:4]), decodeUint32(c.Parameters[4:8]), decodeUint32(c.Parameters[8:]))
+		}
 	case COMMAND_DOWNLOAD:
-		return fmt.Sprintf("%v (addr=0x%s, size=%d)", c.Type, hex.EncodeToString(c.Parameters[0:4]), decodeUint32(c.Parameters[4:8]))
+		if len(c.Parameters) == 8 {
+			return fmt.Sprintf("%v (addr=0x%s, size=%d)", c.Type, hex.EncodeToString(c.Parameters[0:4]), decodeUint32(c.Parameters[4:8]))
+		}
+	case COMMAND_DOWNLOAD_CRC:
+		if len(c.Parameters) == 12 {
+			return fmt.Sprintf("%v (addr=0x%s, size=%d, crc=0x%s)", c.Type, hex.EncodeToString(c.Parameters[0:4]), decodeUint32(c.Parameters[4:8]), hex.EncodeToString(c.Parameters[8:12]))
+		}
 	case COMMAND_MEMORY_READ:
-		return fmt.Sprintf("%v (addr=0x%s, type=%v, count=%d)", c.Type, hex.EncodeToString(c.Parameters[0:4]), ReadWriteType(c.Parameters[4]), uint8(c.Parameters[5]))
-	default:
-		return fmt.Sprintf("%v [%d]=(%s)", c.Type, len(c.Parameters), hex.EncodeToString(c.Parameters))
+		if len(c.Parameters) == 6 {
+			return fmt.Sprintf("%v (addr=0x%s, type=%v, count=%d)", c.Type, hex.EncodeToString(c.Parameters[0:4]), ReadWriteType(c.Parameters[4]), uint8(c.Parameters[5]))
+		}
 	}
+	// unknown commands and malformed parameters are shown raw
+	return fmt.Sprintf("%v [%d]=(%s)", c.Type, len(c.Parameters), hex.EncodeToString(c.Parameters))
 }
 
 const (
 	SendDataMaxSize = 255 - 3
+	// DownloadMaxSize is the most data WriteFlash programs with a single
+	// Download command
+	DownloadMaxSize = 0x1000
+	// FlashWordSize is the alignment required of Download addresses and
+	// sizes
+	FlashWordSize = 4
+	// VerifyBlockSize is the size of the regions whose CRC32 VerifyFlash
+	// compares
+	VerifyBlockSize = 0x1000
+	// VerifyMinSize is the smallest range VerifyFlash narrows a mismatch
+	// down to
+	VerifyMinSize = 4
 )
 
 // Status represents the status received by the GetStatus command
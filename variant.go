@@ -0,0 +1,211 @@
+package ccboot
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupported is matched by errors for commands that the device's
+// bootloader does not have
+var ErrUnsupported = errors.New("Command is not supported by the device")
+
+// Variant describes the dialect of the serial bootloader spoken by a
+// family of chips
+type Variant struct {
+	Name string
+	// FlashBase is the address of the first byte of flash
+	FlashBase uint32
+	// ConfigName and ConfigSize describe the configuration area at the
+	// end of flash, which is the CCFG on CC13xx/CC26xx and the lock bit
+	// page on the CC2538
+	ConfigName string
+	ConfigSize uint32
+	// CRC32ReadCount is set when COMMAND_CRC32 takes a read repeat count
+	CRC32ReadCount bool
+	// EraseSize is the size of the flash sector that COMMAND_SECTOR_ERASE
+	// erases
+	EraseSize uint32
+	// SizedErase is set when COMMAND_SECTOR_ERASE takes the size to erase
+	// after the address
+	SizedErase bool
+	// SingleRead is set when COMMAND_MEMORY_READ reads one unit, given by
+	// its width, instead of a count of units
+	SingleRead bool
+	// SingleWrite is set when COMMAND_MEMORY_WRITE writes one unit, held
+	// in a full word and followed by its width, instead of a count of
+	// units
+	SingleWrite bool
+	// Unsupported lists the commands the bootloader does not have
+	Unsupported []CommandType
+}
+
+// VariantCC26xx is the bootloader of the CC13x0 and CC26x0. It is also
+// used for any chip that is not recognized.
+var VariantCC26xx = &Variant{
+	Name:           "CC13x0/CC26x0",
+	FlashBase:      0x00000000,
+	ConfigName:     "CCFG",
+	ConfigSize:     CCFGSize,
+	EraseSize:      0x1000,
+	CRC32ReadCount: true,
+	Unsupported:    []CommandType{COMMAND_DOWNLOAD_CRC},
+}
+
+// VariantCC26x2 is the bootloader of the CC13x2 and CC26x2, which adds
+// COMMAND_DOWNLOAD_CRC
+var VariantCC26x2 = &Variant{
+	Name:           "CC13x2/CC26x2",
+	FlashBase:      0x00000000,
+	ConfigName:     "CCFG",
+	ConfigSize:     CCFGSize,
+	EraseSize:      0x2000,
+	CRC32ReadCount: true,
+}
+
+// VariantCC2538 is the bootloader of the CC2538
+var VariantCC2538 = &Variant{
+	Name:        "CC2538",
+	FlashBase:   0x00200000,
+	ConfigName:  "lock bit page",
+	ConfigSize:  0x2C,
+	EraseSize:   0x800,
+	SizedErase:  true,
+	SingleRead:  true,
+	SingleWrite: true,
+	Unsupported: []CommandType{COMMAND_BANK_ERASE, COMMAND_SET_CCFG, COMMAND_DOWNLOAD_CRC},
+}
+
+// VariantForChipID returns the variant of the chip that answers
+// GetChipID with chipID. The chip ID of a CC13xx/CC26xx does not tell
+// the CC26x2 apart from the CC26x0, so VariantCC26xx is returned for
+// both.
+func VariantForChipID(chipID uint32) *Variant {
+	if chipID&0xFFFE == 0xB964 {
+		return VariantCC2538
+	}
+	return VariantCC26xx
+}
+
+// VariantForFamily returns the variant of a family of chips
+func VariantForFamily(f Family) *Variant {
+	switch f {
+	case FamilyCC2538:
+		return VariantCC2538
+	case FamilyCC26x2:
+		return VariantCC26x2
+	}
+	return VariantCC26xx
+}
+
+// Supports reports whether the bootloader has cmd
+func (v *Variant) Supports(cmd CommandType) bool {
+	for _, c := range v.Unsupported {
+		if c == cmd {
+			return false
+		}
+	}
+	return true
+}
+
+// ConfigArea returns the range of the configuration area of a chip with
+// flashSize bytes of flash
+func (v *Variant) ConfigArea(flashSize uint32) Range {
+	return Range{Address: v.FlashBase + flashSize - v.ConfigSize, Size: v.ConfigSize}
+}
+
+func (v *Variant) String() string {
+	return v.Name
+}
+
+// UnsupportedError is returned for a command that the device's
+// bootloader does not have. It matches ErrUnsupported.
+type UnsupportedError struct {
+	Command CommandType
+	Variant *Variant
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%v is not supported by the %v bootloader", e.Command, e.Variant)
+}
+
+func (e *UnsupportedError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// Variant returns the bootloader variant the device speaks. It is
+// Options.Variant if set, otherwise the variant found by DetectVariant
+// or Probe, or matching the chip ID read with GetChipID, otherwise
+// VariantCC26xx.
+func (d *Device) Variant() *Variant {
+	if d.opts.Variant != nil {
+		return d.opts.Variant
+	}
+	if d.state.variant != nil {
+		return d.state.variant
+	}
+	return VariantCC26xx
+}
+
+// DetectVariant reads the chip ID, and on a CC13xx/CC26xx the wafer ID,
+// to select the device's variant
+func (d *Device) DetectVariant() (*Variant, error) {
+	chipID, err := d.GetChipID()
+	if err != nil {
+		return nil, err
+	}
+	if VariantForChipID(chipID) == VariantCC26xx {
+		icepick, err := d.readWord(regICEPickDeviceID)
+		if err != nil {
+			return nil, err
+		}
+		d.state.variant = VariantForFamily(waferID2Family[(icepick>>12)&0xFFFF])
+	}
+	d.state.detected = true
+	return d.Variant(), nil
+}
+
+// variant returns the device's variant, detecting it first unless it is
+// set in Options or was already found
+func (d *Device) variant() (*Variant, error) {
+	if d.opts.Variant == nil && !d.state.detected {
+		if _, err := d.DetectVariant(); err != nil {
+			return nil, err
+		}
+	}
+	return d.Variant(), nil
+}
+
+// SectorSize returns the size of the flash sector that SectorErase
+// erases. It is Options.Geometry.SectorSize if set, and otherwise the
+// EraseSize of the device's variant, which is detected first if needed.
+func (d *Device) SectorSize() (uint32, error) {
+	if g := d.opts.Geometry; g.valid() {
+		return g.SectorSize, nil
+	}
+	v, err := d.variant()
+	if err != nil {
+		return 0, err
+	}
+	return v.EraseSize, nil
+}
+
+// checkSectorSize refuses a sectorSize that is not the size of the
+// sectors the device erases
+func (d *Device) checkSectorSize(sectorSize uint32) error {
+	size, err := d.SectorSize()
+	if err != nil {
+		return err
+	}
+	if sectorSize != size {
+		return fmt.Errorf("%w: sector size 0x%X, but the device erases 0x%X bytes", ErrBadArguments, sectorSize, size)
+	}
+	return nil
+}
+
+// supports returns an *UnsupportedError if the device lacks cmd
+func (d *Device) supports(cmd CommandType) error {
+	if v := d.Variant(); !v.Supports(cmd) {
+		return &UnsupportedError{Command: cmd, Variant: v}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package ccboot_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/openchirp/ccboot"
+	"github.com/openchirp/ccboot/ccboottest"
+)
+
+func TestVariantDetect(t *testing.T) {
+	d, _ := newSimDevice(t, ccboottest.CC2538Config)
+	if v := d.Variant(); v != ccboot.VariantCC26xx {
+		t.Errorf("Variant before detection is %v, expected %v", v, ccboot.VariantCC26xx)
+	}
+	v, err := d.DetectVariant()
+	if err != nil {
+		t.Fatalf("Error detecting variant: %v", err)
+	}
+	if v != ccboot.VariantCC2538 {
+		t.Errorf("Detected variant %v, expected %v", v, ccboot.VariantCC2538)
+	}
+	// copies share the detected variant
+	if v := d.WithContext(d.Context()).Variant(); v != ccboot.VariantCC2538 {
+		t.Errorf("Copy has variant %v, expected %v", v, ccboot.VariantCC2538)
+	}
+}
+
+func TestVariantExplicit(t *testing.T) {
+	opts := ccboot.DefaultOptions
+	opts.Variant = ccboot.VariantCC2538
+	d := ccboot.NewDeviceWithOptions(ccboottest.NewBootloader(ccboottest.DefaultConfig), opts)
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+	if _, err := d.GetChipID(); err != nil {
+		t.Fatalf("Error reading chip id: %v", err)
+	}
+	if v := d.Variant(); v != ccboot.VariantCC2538 {
+		t.Errorf("Variant is %v, expected the explicit %v", v, ccboot.VariantCC2538)
+	}
+}
+
+func TestCC2538Flash(t *testing.T) {
+	d, b := newSimDevice(t, ccboottest.CC2538Config)
+	if _, err := d.DetectVariant(); err != nil {
+		t.Fatalf("Error detecting variant: %v", err)
+	}
+	address := uint32(0x200800)
+	data := testImage(0x900)
+
+	if err := d.SectorErase(address); err != nil {
+		t.Fatalf("Error erasing sector: %v", err)
+	}
+	if err := d.SectorErase(address + 0x800); err != nil {
+		t.Fatalf("Error erasing sector: %v", err)
+	}
+	if err := d.WriteFlash(address, data); err != nil {
+		t.Fatalf("Error writing flash: %v", err)
+	}
+	if err := d.VerifyFlash(address, data); err != nil {
+		t.Fatalf("Error verifying flash: %v", err)
+	}
+	if _, err := d.CRC32(address, 4, 1); !errors.Is(err, ccboot.ErrBadArguments) {
+		t.Errorf("CRC32 with a read count returned %v, expected %v", err, ccboot.ErrBadArguments)
+	}
+
+	got, err := d.ReadMemory(address+1, 10)
+	if err != nil {
+		t.Fatalf("Error reading memory: %v", err)
+	}
+	if !bytes.Equal(got, data[1:11]) {
+		t.Errorf("Read back %x, expected %x", got, data[1:11])
+	}
+
+	// erasing the next page leaves the image alone
+	if err := d.SectorErase(address + 0x1000); err != nil {
+		t.Fatalf("Error erasing sector: %v", err)
+	}
+	flash := b.Flash()[address-0x200000:]
+	if !bytes.Equal(flash[:len(data)], data) {
+		t.Error("Erasing the following page damaged the image")
+	}
+}
+
+func TestCC2538Unsupported(t *testing.T) {
+	d, _ := newSimDevice(t, ccboottest.CC2538Config)
+	if _, err := d.DetectVariant(); err != nil {
+		t.Fatalf("Error detecting variant: %v", err)
+	}
+	err := d.SetCCFG(ccboot.ID_BL_ENABLE, 0xC5)
+	if !errors.Is(err, ccboot.ErrUnsupported) {
+		t.Fatalf("SetCCFG returned %v, expected %v", err, ccboot.ErrUnsupported)
+	}
+	var unsupported *ccboot.UnsupportedError
+	if !errors.As(err, &unsupported) || unsupported.Command != ccboot.COMMAND_SET_CCFG {
+		t.Errorf("SetCCFG returned %v, expected an *UnsupportedError for %v", err, ccboot.COMMAND_SET_CCFG)
+	}
+	if err := d.BankErase(); !errors.Is(err, ccboot.ErrUnsupported) {
+		t.Errorf("BankErase returned %v, expected %v", err, ccboot.ErrUnsupported)
+	}
+	// the device was never sent the command
+	status, err := d.GetStatus()
+	if err != nil {
+		t.Fatalf("Error getting status: %v", err)
+	}
+	if status != ccboot.COMMAND_RET_SUCCESS {
+		t.Errorf("Status is %v, expected %v", status, ccboot.COMMAND_RET_SUCCESS)
+	}
+}
+
+func TestCC2538MemoryWrite(t *testing.T) {
+	d, _ := newSimDevice(t, ccboottest.CC2538Config)
+	if _, err := d.DetectVariant(); err != nil {
+		t.Fatalf("Error detecting variant: %v", err)
+	}
+	// each unit is written with its own command
+	if err := d.MemoryWrite(0x20000000, ccboot.ReadWriteType32Bit, []byte{1, 2, 3, 4, 5, 6, 7, 8}); err != nil {
+		t.Fatalf("Error writing words: %v", err)
+	}
+	if err := d.MemoryWrite(0x20000009, ccboot.ReadWriteType8Bit, []byte{9, 10}); err != nil {
+		t.Fatalf("Error writing bytes: %v", err)
+	}
+	got, err := d.ReadMemory(0x20000000, 12)
+	if err != nil {
+		t.Fatalf("Error reading memory: %v", err)
+	}
+	if expected := []byte{1, 2, 3, 4, 5, 6, 7, 8, 0, 9, 10, 0}; !bytes.Equal(got, expected) {
+		t.Errorf("Read back %x, expected %x", got, expected)
+	}
+	if err := d.MemoryWrite(0x20000000, ccboot.ReadWriteType32Bit, []byte{1, 2}); err != ccboot.ErrBadArguments {
+		t.Errorf("MemoryWrite of a partial word returned %v, expected %v", err, ccboot.ErrBadArguments)
+	}
+}
+
+func TestSectorSize(t *testing.T) {
+	for _, tt := range []struct {
+		cfg  ccboottest.Config
+		size uint32
+	}{
+		{ccboottest.DefaultConfig, 0x1000},
+		{ccboottest.CC26x2Config, 0x2000},
+		{ccboottest.CC2538Config, 0x800},
+	} {
+		d, _ := newSimDevice(t, tt.cfg)
+		size, err := d.SectorSize()
+		if err != nil {
+			t.Fatalf("Error getting sector size: %v", err)
+		}
+		if size != tt.size {
+			t.Errorf("%v sector size is 0x%X, expected 0x%X", d.Variant(), size, tt.size)
+		}
+	}
+
+	// a plan of 4 KB sectors does not fit the CC26x2's 8 KB erase
+	d, _ := newSimDevice(t, ccboottest.CC26x2Config)
+	plan, err := ccboot.PlanErase(cc2650Geometry, []ccboot.Range{{Address: 0x1000, Size: 0x1000}})
+	if err != nil {
+		t.Fatalf("Error planning erase: %v", err)
+	}
+	if err := d.Erase(plan); !errors.Is(err, ccboot.ErrBadArguments) {
+		t.Errorf("Erase with 4 KB sectors returned %v, expected %v", err, ccboot.ErrBadArguments)
+	}
+}
+
+func TestDownloadCRC(t *testing.T) {
+	sent := make(map[ccboot.CommandType]int)
+	opts := ccboot.DefaultOptions
+	opts.Tracer = ccboot.TracerFunc(func(f ccboot.Frame) {
+		if f.Dir == ccboot.FrameSent && f.Kind == ccboot.FramePacket {
+			sent[f.Command]++
+		}
+	})
+	b := ccboottest.NewBootloader(ccboottest.CC26x2Config)
+	d := ccboot.NewDeviceWithOptions(b, opts)
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+	v, err := d.DetectVariant()
+	if err != nil {
+		t.Fatalf("Error detecting variant: %v", err)
+	}
+	if v != ccboot.VariantCC26x2 {
+		t.Fatalf("Detected variant %v, expected %v", v, ccboot.VariantCC26x2)
+	}
+
+	data := testImage(0x1800)
+	if err := d.WriteFlash(0x2000, data); err != nil {
+		t.Fatalf("Error writing flash: %v", err)
+	}
+	if sent[ccboot.COMMAND_DOWNLOAD_CRC] != 2 || sent[ccboot.COMMAND_DOWNLOAD] != 0 {
+		t.Errorf("WriteFlash sent %d DOWNLOAD_CRC and %d DOWNLOAD, expected 2 and 0", sent[ccboot.COMMAND_DOWNLOAD_CRC], sent[ccboot.COMMAND_DOWNLOAD])
+	}
+	if !bytes.Equal(b.Flash()[0x2000:0x3800], data) {
+		t.Error("Flash does not hold the written data")
+	}
+
+	// unaligned writes next to programmed bytes are padded with what the
+	// device holds, so that its CRC check passes
+	img := ccboot.NewImage(0x4000, []byte{1, 2})
+	if err := img.Add(0x4003, []byte{3, 4, 5}); err != nil {
+		t.Fatalf("Error building image: %v", err)
+	}
+	if err := d.WriteImage(img); err != nil {
+		t.Fatalf("Error writing adjacent unaligned segments: %v", err)
+	}
+	if got := b.Flash()[0x4000:0x4008]; !bytes.Equal(got, []byte{1, 2, 0xFF, 3, 4, 5, 0xFF, 0xFF}) {
+		t.Errorf("Flash holds %x after writing adjacent unaligned segments", got)
+	}
+
+	// programming over the data cannot set bits, which the device's CRC
+	// check catches
+	inverted := make([]byte, 0x10)
+	for i := range inverted {
+		inverted[i] = ^data[i]
+	}
+	err = d.WriteFlash(0x2000, inverted)
+	var cmdErr *ccboot.CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("WriteFlash over programmed flash returned %v, expected a *CommandError", err)
+	}
+	if cmdErr.Command != ccboot.COMMAND_SEND_DATA || cmdErr.Status != ccboot.COMMAND_RET_FLASH_FAIL {
+		t.Errorf("WriteFlash failed with %v, expected %v from %v", cmdErr, ccboot.COMMAND_RET_FLASH_FAIL, ccboot.COMMAND_SEND_DATA)
+	}
+}
+
+func TestDownloadCRCDetected(t *testing.T) {
+	// with the lockout check skipped, WriteFlash itself detects the
+	// variant
+	sent := make(map[ccboot.CommandType]int)
+	opts := ccboot.DefaultOptions
+	opts.AllowLockout = true
+	opts.Tracer = ccboot.TracerFunc(func(f ccboot.Frame) {
+		if f.Dir == ccboot.FrameSent && f.Kind == ccboot.FramePacket {
+			sent[f.Command]++
+		}
+	})
+	b := ccboottest.NewBootloader(ccboottest.CC26x2Config)
+	d := ccboot.NewDeviceWithOptions(b, opts)
+	if err := d.Sync(); err != nil {
+		t.Fatalf("Error syncing to simulator: %v", err)
+	}
+	if err := d.WriteFlash(0x2000, testImage(0x100)); err != nil {
+		t.Fatalf("Error writing flash: %v", err)
+	}
+	if sent[ccboot.COMMAND_DOWNLOAD_CRC] != 1 || sent[ccboot.COMMAND_DOWNLOAD] != 0 {
+		t.Errorf("WriteFlash sent %d DOWNLOAD_CRC and %d DOWNLOAD, expected 1 and 0", sent[ccboot.COMMAND_DOWNLOAD_CRC], sent[ccboot.COMMAND_DOWNLOAD])
+	}
+}
+
+func TestDownloadCRCUnsupported(t *testing.T) {
+	d, _ := newSimDevice(t, ccboottest.DefaultConfig)
+	v, err := d.DetectVariant()
+	if err != nil {
+		t.Fatalf("Error detecting variant: %v", err)
+	}
+	if v != ccboot.VariantCC26xx {
+		t.Errorf("Detected variant %v, expected %v", v, ccboot.VariantCC26xx)
+	}
+	if err := d.DownloadCRC(0x1000, 4, 0); !errors.Is(err, ccboot.ErrUnsupported) {
+		t.Errorf("DownloadCRC returned %v, expected %v", err, ccboot.ErrUnsupported)
+	}
+}